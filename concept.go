@@ -1,11 +1,14 @@
 package concept
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dityaaa/concept/database"
 	"github.com/dityaaa/concept/internal/natsort"
 	"github.com/dityaaa/concept/source"
+	"io"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -16,15 +19,37 @@ import (
 type Concept struct {
 	databaseDriver database.Driver
 	sourceDriver   source.Driver
+	registry       *Registry
+	registryMerged bool
 
 	versions   []string
 	migrations map[string]*Migration
 
-	pattern *regexp.Regexp
+	// repeatable migrations are applied in name order after every versioned
+	// migration, superseded keeps their earlier runs.
+	repeatableNames []string
+	repeatables     map[string]*Migration
+	superseded      map[string][]*Migration
+
+	pattern           *regexp.Regexp
+	repeatablePattern *regexp.Regexp
 
 	latestErr    error
 	unpairedRevs int
+	unpaired     []string
+	mismatches   map[string]string
+	synced       bool
 	outOfOrder   bool
+	ignored      []string
+
+	baselineVersion string
+	environment     string
+	placeholders    map[string]string
+
+	batchTransaction  bool
+	inBatch           bool
+	validateOnMigrate bool
+	failure           *database.History
 
 	latestSourceVersion   string
 	latestDatabaseVersion string
@@ -62,9 +87,15 @@ func NewWithInstance(database database.Driver, source source.Driver) (*Concept,
 	inst := &Concept{
 		databaseDriver: database,
 		sourceDriver:   source,
+		registry:       DefaultRegistry,
 		versions:       make([]string, 0),
 		migrations:     make(map[string]*Migration, 0),
 		pattern:        regexp.MustCompile(`(\d+?)(?:_(\w*))?(?:\.(adv|rev))?.sql$`),
+
+		repeatableNames:   make([]string, 0),
+		repeatables:       make(map[string]*Migration, 0),
+		superseded:        make(map[string][]*Migration, 0),
+		repeatablePattern: regexp.MustCompile(`(?:^|[/\\])R__(\w+)\.sql$`),
 	}
 	inst.ClearHooks()
 
@@ -72,7 +103,11 @@ func NewWithInstance(database database.Driver, source source.Driver) (*Concept,
 }
 
 func (i *Concept) SetHooks(hooks *Hooks) {
-	types := reflect.TypeOf(Hooks{}).Elem()
+	if hooks == nil {
+		return
+	}
+
+	types := reflect.TypeOf(Hooks{})
 
 	for c := 0; c < types.NumField(); c++ {
 		srcField := reflect.ValueOf(hooks).Elem().Field(c)
@@ -91,26 +126,16 @@ func (i *Concept) ClearHooks() {
 		PreRollback:  func(m *Migration) {},
 		PostRollback: func(m *Migration) {},
 		RollbackErr:  func(m *Migration, err error) {},
+		Statement:    func(m *Migration, event *database.StatementEvent) {},
 	}
 }
 
 func (i *Concept) Create(name string, rev bool) ([]string, error) {
-	latestVer, err := strconv.Atoi(i.latestSourceVersion)
-	if err != nil {
-		return nil, errors.New("concept: create only support sequential version name")
-	}
-
-	latestDatabaseVersion, err := strconv.Atoi(i.latestDatabaseVersion)
+	name, err := i.nextName(name)
 	if err != nil {
-		return nil, errors.New("concept: create only support sequential version name")
-	}
-
-	if latestVer < latestDatabaseVersion {
-		return nil, errors.New("concept: outdated source migration")
+		return nil, err
 	}
 
-	latestVer++
-	name = fmt.Sprintf("%05d_%s", latestVer, name)
 	// TODO: support for customizable adv/rev suffix
 	files := []string{
 		name + ".sql",
@@ -143,107 +168,745 @@ func (i *Concept) Create(name string, rev bool) ([]string, error) {
 	return files, nil
 }
 
+// CreateSingleFile creates a new migration holding both its up and down
+// sections, the down section may be left empty or removed, either way the
+// migration is not reversible.
+func (i *Concept) CreateSingleFile(name string) (string, error) {
+	writer, ok := i.sourceDriver.(source.Writer)
+	if !ok {
+		return "", fmt.Errorf("concept: %v source does not support writing migrations", i.sourceDriver.Name())
+	}
+
+	name, err := i.nextName(name)
+	if err != nil {
+		return "", err
+	}
+
+	name += ".sql"
+	if _, err = writer.Write(name, strings.NewReader(singleFileTemplate)); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// nextName returns the name of a new migration, numbered after the latest
+// version of both the source and the database.
+func (i *Concept) nextName(name string) (string, error) {
+	latestVer, err := sequence(i.latestSourceVersion)
+	if err != nil {
+		return "", err
+	}
+
+	latestDatabaseVersion, err := sequence(i.latestDatabaseVersion)
+	if err != nil {
+		return "", err
+	}
+
+	if latestVer < latestDatabaseVersion {
+		return "", errors.New("concept: outdated source migration")
+	}
+
+	latestVer++
+	return fmt.Sprintf("%05d_%s", latestVer, name), nil
+}
+
+// sequence returns the number of a sequential version, zero when there is no
+// version yet.
+func sequence(version string) (int, error) {
+	if version == "" {
+		return 0, nil
+	}
+
+	number, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, errors.New("concept: create only support sequential version name")
+	}
+
+	return number, nil
+}
+
+// Migrate applies the given number of pending migrations, a negative number of
+// steps applies all of them.
 func (i *Concept) Migrate(steps int) error {
-	count := 0
-	for _, version := range i.versions {
-		mg := i.migrations[version]
+	return i.MigrateContext(context.Background(), steps)
+}
+
+// MigrateContext is like Migrate, the running script is interrupted and recorded
+// as failed when ctx is done.
+func (i *Concept) MigrateContext(ctx context.Context, steps int) error {
+	return i.locked(ctx, func() error {
+		return i.migrate(ctx, steps, "")
+	})
+}
+
+// MigrateTo applies every pending migration up to and including version.
+func (i *Concept) MigrateTo(version string) error {
+	return i.MigrateToContext(context.Background(), version)
+}
+
+// MigrateToContext is like MigrateTo, the running script is interrupted and
+// recorded as failed when ctx is done.
+func (i *Concept) MigrateToContext(ctx context.Context, version string) error {
+	return i.locked(ctx, func() error {
+		return i.migrate(ctx, -1, version)
+	})
+}
+
+func (i *Concept) migrate(ctx context.Context, steps int, target string) error {
+	if i.validateOnMigrate {
+		report, err := i.Validate()
+		if err != nil {
+			return err
+		}
+
+		if err = report.Err(); err != nil {
+			return err
+		}
+	}
+
+	targets, err := i.advanceTargets(steps, target)
+	if err != nil {
+		return err
+	}
+
+	if err = i.checkNoTransaction(targets, AdvanceDirection); err != nil {
+		return err
+	}
+
+	if err = i.checkPlaceholders(targets, AdvanceDirection); err != nil {
+		return err
+	}
+
+	return i.batch(ctx, func() error {
+		for _, mg := range targets {
+			if err := i.execute(ctx, mg, AdvanceDirection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Baseline adopts a database created without concept. It records a baseline
+// entry at version, every migration at or below it is then considered as
+// applied without being run. The history must not contain any migration yet.
+func (i *Concept) Baseline(version, description string) error {
+	return i.BaselineContext(context.Background(), version, description)
+}
+
+// BaselineContext is like Baseline, recording the baseline stops when ctx is
+// done.
+func (i *Concept) BaselineContext(ctx context.Context, version, description string) error {
+	if version == "" {
+		return errors.New("concept: baseline version cannot be empty")
+	}
+
+	if description == "" {
+		description = "Baseline"
+	}
+
+	return i.locked(ctx, func() error {
+		if i.baselineVersion != "" {
+			return fmt.Errorf("concept: database is already baselined at version %v", i.baselineVersion)
+		}
+
+		for _, mg := range i.migrations {
+			if mg.State&(successState|failedState) > 0 {
+				return errors.New("concept: cannot baseline a database with migration history")
+			}
+		}
+
+		// "5" baselines the source migration "00005" when there is one.
+		if index, err := i.versionIndex(version); err == nil {
+			version = i.versions[index]
+		}
+
+		hs := &database.History{
+			Mode:        BaselineDirection,
+			Version:     version,
+			ScriptName:  "<< " + description + " >>",
+			Description: description,
+			AppliedAt:   uint64(time.Now().Unix()),
+			Success:     true,
+		}
+
+		if err := database.WriteContext(ctx, i.databaseDriver, hs); err != nil {
+			return err
+		}
+
+		return i.sync(ctx)
+	})
+}
+
+// Squash replaces every migration up to and including version by a single
+// script holding the schema they produce, then removes their scripts from the
+// source. The schema is dumped from the database, which must be migrated up to
+// version exactly. Databases that applied the squashed migrations keep working
+// with the new script, fresh ones only run the squash script. It returns the
+// identifier of the new script and of the removed ones.
+func (i *Concept) Squash(version string) (string, []string, error) {
+	return i.SquashContext(context.Background(), version)
+}
+
+// SquashContext is like Squash, dumping the schema and recording the squash
+// stop when ctx is done.
+func (i *Concept) SquashContext(ctx context.Context, version string) (string, []string, error) {
+	dumper, ok := i.databaseDriver.(database.Dumper)
+	if !ok {
+		return "", nil, fmt.Errorf("concept: %v driver does not support schema dump", i.databaseDriver.Name())
+	}
+
+	writer, ok := i.sourceDriver.(source.Writer)
+	if !ok {
+		return "", nil, fmt.Errorf("concept: %v source does not support writing migrations", i.sourceDriver.Name())
+	}
+
+	var created string
+	var removed []string
+
+	err := i.locked(ctx, func() error {
+		index, err := i.versionIndex(version)
+		if err != nil {
+			return err
+		}
+		version = i.versions[index]
+
+		for c, candidate := range i.versions {
+			mg := i.migrations[candidate]
+			applied := (mg.State&successState > 0 && mg.State&undoneState == 0) || mg.State&baselineState > 0
+
+			if mg.State&failedState > 0 {
+				return fmt.Errorf("last database migration is failed. manual cleaning needed at version: %s", mg.Version)
+			}
+
+			if c <= index && mg.AdvanceScript != nil && mg.AdvanceScript.fn != nil {
+				return fmt.Errorf("concept: cannot squash, migration %v is written in go", mg.Version)
+			}
+
+			if c <= index && !applied {
+				return fmt.Errorf("concept: cannot squash, migration %v is not applied", mg.Version)
+			}
+
+			if c > index && applied {
+				return fmt.Errorf("concept: cannot squash, migration %v above version %v is applied", mg.Version, version)
+			}
+		}
+
+		dump := &bytes.Buffer{}
+		if err = dumper.Dump(dump, database.DumpOptions{}); err != nil {
+			return err
+		}
+		content := escapePlaceholders(dump.Bytes())
+
+		created, err = writer.Write(fmt.Sprintf("%s_%s.sql", version, SquashDescription), bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+
+		script := &Script{
+			Version:     version,
+			Identifier:  created,
+			Description: SquashDescription,
+			Direction:   AdvanceDirection,
+		}
+		script.SetContent(io.NopCloser(bytes.NewReader(content)))
+
+		removed = make([]string, 0)
+		for _, candidate := range i.versions[:index+1] {
+			mg := i.migrations[candidate]
+			for _, old := range []*Script{mg.AdvanceScript, mg.ReverseScript} {
+				if old == nil || old.Identifier == created {
+					continue
+				}
+
+				// both sections of a single-file migration are in the same file.
+				if old == mg.ReverseScript && mg.AdvanceScript != nil && old.Identifier == mg.AdvanceScript.Identifier {
+					continue
+				}
+
+				if err = i.sourceDriver.Remove(old.Identifier); err != nil {
+					return err
+				}
+				removed = append(removed, old.Identifier)
+			}
+
+			delete(i.migrations, candidate)
+		}
+
+		i.versions = append([]string{version}, i.versions[index+1:]...)
+		i.migrations[version] = &Migration{
+			Version:       version,
+			Description:   SquashDescription,
+			State:         pendingState,
+			AdvanceScript: script,
+		}
+
+		hs := &database.History{
+			Mode:        SquashDirection,
+			Version:     version,
+			ScriptName:  created,
+			Description: SquashDescription,
+			Checksum:    script.Checksum(),
+			AppliedAt:   uint64(time.Now().Unix()),
+			Success:     true,
+		}
+
+		if err = database.WriteContext(ctx, i.databaseDriver, hs); err != nil {
+			return err
+		}
+
+		return i.sync(ctx)
+	})
+
+	return created, removed, err
+}
+
+// Rollback reverts the given number of applied migrations, a negative number of
+// steps reverts as many as possible.
+func (i *Concept) Rollback(steps int) error {
+	return i.RollbackContext(context.Background(), steps)
+}
+
+// RollbackContext is like Rollback, the running script is interrupted and
+// recorded as failed when ctx is done.
+func (i *Concept) RollbackContext(ctx context.Context, steps int) error {
+	return i.locked(ctx, func() error {
+		return i.rollback(ctx, steps, "")
+	})
+}
+
+// RollbackTo reverts every applied migration above version, version itself
+// stays applied.
+func (i *Concept) RollbackTo(version string) error {
+	return i.RollbackToContext(context.Background(), version)
+}
+
+// RollbackToContext is like RollbackTo, the running script is interrupted and
+// recorded as failed when ctx is done.
+func (i *Concept) RollbackToContext(ctx context.Context, version string) error {
+	return i.locked(ctx, func() error {
+		return i.rollback(ctx, -1, version)
+	})
+}
+
+func (i *Concept) rollback(ctx context.Context, steps int, target string) error {
+	targets, err := i.reverseTargets(steps, target)
+	if err != nil {
+		return err
+	}
+
+	if err = i.checkNoTransaction(targets, ReverseDirection); err != nil {
+		return err
+	}
+
+	if err = i.checkPlaceholders(targets, ReverseDirection); err != nil {
+		return err
+	}
+
+	return i.batch(ctx, func() error {
+		for _, mg := range targets {
+			if err := i.execute(ctx, mg, ReverseDirection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Targets returns, in execution order, the migrations that MigrateTo or
+// RollbackTo would run for the given direction and target version. An empty
+// target selects every migration.
+func (i *Concept) Targets(direction Direction, target string) ([]*Migration, error) {
+	if i.latestErr != nil {
+		return nil, i.latestErr
+	}
+
+	if direction == ReverseDirection {
+		return i.reverseTargets(-1, target)
+	}
+
+	return i.advanceTargets(-1, target)
+}
+
+func (i *Concept) advanceTargets(steps int, target string) ([]*Migration, error) {
+	if len(i.ignored) > 0 && !i.outOfOrder {
+		return nil, fmt.Errorf("concept: found %v pending migration below applied version %v %v, out of order migrations are not allowed", len(i.ignored), i.latestDatabaseVersion, i.ignored)
+	}
+
+	end := len(i.versions) - 1
+	if target != "" {
+		index, err := i.versionIndex(target)
+		if err != nil {
+			return nil, err
+		}
+
+		if i.migrations[i.versions[index]].AdvanceScript == nil {
+			return nil, fmt.Errorf("concept: target version %v has no advance script", target)
+		}
+		end = index
+	}
+
+	c := 0
+	targets := make([]*Migration, 0)
+	for ; c <= end && (steps < 0 || len(targets) < steps); c++ {
+		mg := i.migrations[i.versions[c]]
+		if mg.State&failedState > 0 {
+			return nil, fmt.Errorf("last database migration is failed. manual cleaning needed at version: %s", mg.Version)
+		}
+
 		if (mg.State&pendingState) != pendingState && (mg.State&undoneState) != undoneState {
 			continue
 		}
 
-		count++
-		if steps < 0 && count > steps {
+		if mg.State&excludedState > 0 {
+			continue
+		}
+
+		targets = append(targets, mg)
+	}
+
+	// repeatable migrations are written against the latest schema, they only
+	// run once every versioned migration is applied.
+	if c < len(i.versions) {
+		return targets, i.checkRequires(targets)
+	}
+
+	for _, name := range i.repeatableNames {
+		if steps >= 0 && len(targets) >= steps {
 			break
 		}
 
-		if mg.State&failedState > 0 {
-			return fmt.Errorf("last database migration is failed. manual cleaning needed at version: %s", mg.Version)
+		mg := i.repeatables[name]
+		if mg.AdvanceScript == nil {
+			continue
 		}
 
-		hs := &database.History{
-			Mode:        AdvanceDirection,
-			Version:     mg.Version,
-			ScriptName:  mg.AdvanceScript.Identifier,
-			Description: mg.Description,
-			Checksum:    mg.AdvanceScript.Checksum(),
-			AppliedAt:   uint64(time.Now().Unix()),
+		// a failed repeatable migration is retried once its script is changed.
+		if mg.State&failedState > 0 && mg.State&outdatedState == 0 {
+			return nil, fmt.Errorf("last database migration is failed. manual cleaning needed at repeatable: %s", mg.Description)
+		}
+
+		if mg.State&(pendingState|outdatedState) == 0 || mg.State&excludedState > 0 {
+			continue
+		}
+
+		targets = append(targets, mg)
+	}
+
+	return targets, i.checkRequires(targets)
+}
+
+func (i *Concept) reverseTargets(steps int, target string) ([]*Migration, error) {
+	end := 0
+	if target != "" {
+		index, err := i.versionIndex(target)
+		if err != nil {
+			return nil, err
+		}
+		end = index + 1
+	}
+
+	targets := make([]*Migration, 0)
+	for c := len(i.versions) - 1; c >= end && (steps < 0 || len(targets) < steps); c-- {
+		mg := i.migrations[i.versions[c]]
+
+		if target != "" && mg.State&baselineState > 0 {
+			return nil, fmt.Errorf("concept: cannot roll back to version %v, migration %v is part of the baseline", target, mg.Version)
+		}
+
+		applied := mg.State&successState > 0 && mg.State&undoneState == 0
+		if target != "" && applied && mg.State&availableState == 0 {
+			return nil, fmt.Errorf("concept: cannot roll back to version %v, migration %v is not reversible", target, mg.Version)
+		}
+
+		if (mg.State&availableState) != availableState || (mg.State&undoneState) == undoneState {
+			continue
+		}
+
+		targets = append(targets, mg)
+	}
+
+	return targets, i.checkRequired(targets)
+}
+
+// versionIndex resolves version to its position in the natsort-ordered
+// versions. Leading zeros are not significant, "12" resolves "00012".
+func (i *Concept) versionIndex(version string) (int, error) {
+	if _, exists := i.migrations[version]; !exists {
+		for _, candidate := range i.versions {
+			if strings.TrimLeft(candidate, "0") == strings.TrimLeft(version, "0") {
+				version = candidate
+				break
+			}
+		}
+	}
+
+	for c, candidate := range i.versions {
+		if candidate == version {
+			return c, nil
+		}
+	}
+
+	return 0, fmt.Errorf("concept: unknown target version %v", version)
+}
+
+// locked runs fn while holding the shared lock.
+func (i *Concept) locked(ctx context.Context, fn func() error) (err error) {
+	if err = i.lock(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := i.unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+
+	return fn()
+}
+
+// SetOutOfOrder allows the next runs to apply pending migrations whose version
+// is lower than an already applied one, they are applied in version order and
+// recorded as out of order in the history. Without it such migrations are
+// ignored and migrate refuses to run.
+func (i *Concept) SetOutOfOrder(enabled bool) {
+	i.outOfOrder = enabled
+}
+
+// SetBatchTransaction makes the next runs wrap every migration of the run in a
+// single transaction, so either all of them are applied or none. It requires a
+// database driver with transactional DDL.
+func (i *Concept) SetBatchTransaction(enabled bool) {
+	i.batchTransaction = enabled
+}
+
+// batch runs fn inside a single transaction when batch transaction is enabled.
+// On failure everything is rolled back and only the failure is recorded.
+func (i *Concept) batch(ctx context.Context, fn func() error) error {
+	if !i.batchTransaction {
+		return fn()
+	}
+
+	transactor, ok := i.databaseDriver.(database.Transactor)
+	if !ok {
+		return fmt.Errorf("concept: %v driver does not support transactions", i.databaseDriver.Name())
+	}
+
+	if err := transactor.TransactionalDDL(); err != nil {
+		return fmt.Errorf("concept: migrations cannot be wrapped in a single transaction: %w", err)
+	}
+
+	if err := transactor.Begin(); err != nil {
+		return err
+	}
+
+	i.inBatch = true
+	err := fn()
+	i.inBatch = false
+
+	if err == nil {
+		err = transactor.Commit()
+		if err == nil {
+			return nil
+		}
+	}
+
+	if rollbackErr := transactor.Rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+
+	// the failure is recorded even when ctx is what interrupted the run.
+	if i.failure != nil {
+		if writeErr := i.databaseDriver.Write(i.failure); writeErr != nil {
+			return fmt.Errorf("%w (recording failure failed: %v)", err, writeErr)
 		}
-		err := i.databaseDriver.Write(hs)
+		i.failure = nil
+	}
+
+	// states changed by the migrations that were rolled back are stale.
+	if syncErr := i.sync(context.Background()); syncErr != nil {
+		return fmt.Errorf("%w (reloading history failed: %v)", err, syncErr)
+	}
+
+	return err
+}
+
+// execute runs a single migration script in the given direction and records
+// the outcome in the history. Drivers with transactional DDL run the script and
+// its history entry atomically, others record a failed entry first and mark it
+// as succeeded once the script has been run.
+func (i *Concept) execute(ctx context.Context, mg *Migration, direction Direction) error {
+	script := mg.AdvanceScript
+	preHook, postHook, errHook := i.hooks.PreMigrate, i.hooks.PostMigrate, i.hooks.MigrateErr
+	if direction == ReverseDirection {
+		script = mg.ReverseScript
+		preHook, postHook, errHook = i.hooks.PreRollback, i.hooks.PostRollback, i.hooks.RollbackErr
+	}
+
+	mode, version := string(direction), mg.Version
+	if mg.Repeatable {
+		mode, version = RepeatableDirection, mg.Description
+	} else if direction == AdvanceDirection && mg.State&ignoredState > 0 {
+		mode = OutOfOrderDirection
+	}
+
+	hs := &database.History{
+		Mode:        mode,
+		Version:     version,
+		ScriptName:  script.Identifier,
+		Description: mg.Description,
+		Checksum:    script.Checksum(),
+		AppliedAt:   uint64(time.Now().Unix()),
+	}
+
+	preHook(mg)
+
+	transactor, transactional := i.databaseDriver.(database.Transactor)
+	transactional = transactional && !i.inBatch && !script.Directives.NoTransaction && transactor.TransactionalDDL() == nil
+
+	fail := func(err error) error {
+		mg.State |= failedState
+		errHook(mg, err)
+		return err
+	}
+
+	content, err := script.Content()
+	if err != nil {
+		return fail(err)
+	}
+
+	if script.fn == nil {
+		if content, err = i.substitute(script, content); err != nil {
+			return fail(err)
+		}
+	}
 
-		currentTime := time.Now()
-		executionTime := uint32(currentTime.UnixMilli())
-		if err := i.databaseDriver.Run(mg.AdvanceScript); err != nil {
-			return err
+	// the failed entry is recorded up front, so a crash in the middle of the
+	// script leaves a trace behind.
+	if !transactional && !i.inBatch {
+		if err := database.WriteContext(ctx, i.databaseDriver, hs); err != nil {
+			return fail(err)
 		}
-		executionTime = uint32(time.Now().UnixMilli()) - executionTime
+	}
 
-		mg.AppliedAt = hs.AppliedAt
-		mg.ExecutionTime = executionTime
-		hs.ExecutionTime = executionTime
-		hs.Success = true
-		mg.State &= ^pendingState
-		mg.State &= ^undoneState
+	if transactional {
+		if err := transactor.Begin(); err != nil {
+			return fail(err)
+		}
+	}
 
-		err = i.databaseDriver.Write(hs)
-		if err != nil {
-			mg.State |= failedState
-			return err
+	progress := func(event *database.StatementEvent) {
+		if event.Err == nil {
+			hs.Statements++
 		}
+		i.hooks.Statement(mg, event)
+	}
 
-		mg.State |= successState
+	runCtx := ctx
+	if script.Directives.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, script.Directives.Timeout)
+		defer cancel()
 	}
 
-	return nil
-}
+	startTime := time.Now()
+	if script.fn != nil {
+		err = i.runGo(runCtx, script.fn)
+	} else {
+		err = database.RunStatementsContext(runCtx, i.databaseDriver, bytes.NewReader(content), progress)
+	}
 
-func (i *Concept) Rollback(steps int) error {
-	count := 0
-	for c := len(i.versions) - 1; c >= 0 && (steps < 0 || count < steps); c-- {
-		version := i.versions[c]
-		mg := i.migrations[version]
+	if err != nil && ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("concept: %v exceeded its timeout of %v: %w", script.Identifier, script.Directives.Timeout, err)
+	}
+	hs.ExecutionTime = uint32(time.Since(startTime).Milliseconds())
+	mg.ExecutionTime = hs.ExecutionTime
+	mg.Statements = hs.Statements
 
-		if (mg.State&availableState) != availableState || (mg.State&undoneState) == undoneState {
-			continue
+	if err == nil {
+		hs.Success = true
+		err = database.WriteContext(ctx, i.databaseDriver, hs)
+	}
+
+	if transactional {
+		if err == nil {
+			err = transactor.Commit()
 		}
 
-		count++
+		if err != nil {
+			_ = transactor.Rollback()
 
-		hs := &database.History{
-			Mode:        ReverseDirection,
-			Version:     mg.Version,
-			ScriptName:  mg.ReverseScript.Identifier,
-			Description: mg.Description,
-			Checksum:    mg.ReverseScript.Checksum(),
-			AppliedAt:   uint64(time.Now().Unix()),
+			// nothing the script ran is left behind once rolled back.
+			hs.Statements = 0
+			mg.Statements = 0
+
+			// the failure is recorded even when ctx is what interrupted the run.
+			hs.Rank = 0
+			hs.Success = false
+			if writeErr := i.databaseDriver.Write(hs); writeErr != nil {
+				err = fmt.Errorf("%w (recording failure failed: %v)", err, writeErr)
+			}
 		}
-		err := i.databaseDriver.Write(hs)
+	}
 
-		executionTime := uint32(hs.AppliedAt)
+	if err != nil {
+		// the whole batch is rolled back, so is every statement of the script.
+		if i.inBatch {
+			hs.Rank = 0
+			hs.Success = false
+			hs.Statements = 0
+			mg.Statements = 0
+			i.failure = hs
+		}
 
-		if err := i.databaseDriver.Run(mg.ReverseScript); err != nil {
-			return err
+		// the failed entry recorded up front learns where the script stopped.
+		if !transactional && !i.inBatch && hs.Statements > 0 {
+			hs.Success = false
+			if writeErr := i.databaseDriver.Write(hs); writeErr != nil {
+				err = fmt.Errorf("%w (recording failure failed: %v)", err, writeErr)
+			}
 		}
 
-		executionTime = uint32(time.Now().UnixMilli()) - executionTime
-		mg.ExecutionTime = executionTime
-		hs.ExecutionTime = executionTime
-		hs.Success = true
-		mg.State |= pendingState
+		return fail(err)
+	}
 
-		err = i.databaseDriver.Write(hs)
-		if err != nil {
-			mg.State |= failedState
-			return err
-		}
+	if mg.Repeatable && mg.AppliedAt != 0 {
+		i.supersede(mg)
+	}
 
-		mg.State |= undoneState
+	mg.AppliedBy = hs.AppliedBy
+	mg.AppliedAt = hs.AppliedAt
+	if mg.Repeatable {
+		mg.State = successState
+	} else if direction == ReverseDirection {
+		mg.OutOfOrder = false
+		mg.State |= pendingState | undoneState
+		mg.State &^= availableState
+	} else {
+		mg.OutOfOrder = mode == OutOfOrderDirection
+		mg.State &^= pendingState | undoneState | ignoredState
+		mg.State |= successState
+		if mg.Reversible() {
+			mg.State |= availableState
+		}
 	}
+	i.resolveAvailability()
+	i.resolveOutOfOrder()
 
+	postHook(mg)
 	return nil
 }
 
 func (i *Concept) Refresh() error {
-	return i.rebuild()
+	return i.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, reading the source and the history stops
+// when ctx is done.
+func (i *Concept) RefreshContext(ctx context.Context) error {
+	return i.rebuild(ctx)
 }
 
 func (i *Concept) Get() ([]*Migration, error) {
@@ -251,10 +914,15 @@ func (i *Concept) Get() ([]*Migration, error) {
 		return nil, i.latestErr
 	}
 
-	migrations := make([]*Migration, 0, len(i.migrations))
+	migrations := make([]*Migration, 0, len(i.migrations)+len(i.repeatables))
 	for _, version := range i.versions {
 		migrations = append(migrations, i.migrations[version])
 	}
+
+	for _, name := range i.repeatableNames {
+		migrations = append(migrations, i.superseded[name]...)
+		migrations = append(migrations, i.repeatables[name])
+	}
 	return migrations, nil
 }
 
@@ -266,8 +934,99 @@ func (i *Concept) Purge() error {
 	return nil
 }
 
-func (i *Concept) rebuild() error {
+// Objects lists, in drop order, the database objects selected by opts.
+func (i *Concept) Objects(opts database.CleanOptions) ([]database.Object, error) {
+	cleaner, ok := i.databaseDriver.(database.Cleaner)
+	if !ok {
+		return nil, fmt.Errorf("concept: %v driver does not support selective clean", i.databaseDriver.Name())
+	}
+
+	return cleaner.Objects(opts)
+}
+
+// Clean drops the given objects, as listed by Objects. Every object that could
+// not be dropped is reported by its own database.ObjectError.
+func (i *Concept) Clean(objects []database.Object) []error {
+	cleaner, ok := i.databaseDriver.(database.Cleaner)
+	if !ok {
+		return []error{fmt.Errorf("concept: %v driver does not support selective clean", i.databaseDriver.Name())}
+	}
+
+	return cleaner.Clean(objects)
+}
+
+// Dump writes a script recreating the current database schema, see
+// database.Dumper.
+func (i *Concept) Dump(w io.Writer, opts database.DumpOptions) error {
+	dumper, ok := i.databaseDriver.(database.Dumper)
+	if !ok {
+		return fmt.Errorf("concept: %v driver does not support schema dump", i.databaseDriver.Name())
+	}
+
+	return dumper.Dump(w, opts)
+}
+
+// lock acquires the shared lock when the database driver supports it, then
+// reloads the history since another process may have migrated while waiting.
+func (i *Concept) lock(ctx context.Context) error {
+	if i.latestErr != nil {
+		return i.latestErr
+	}
+
+	locker, ok := i.databaseDriver.(database.Locker)
+	if !ok || !locker.Lockable() {
+		return nil
+	}
+
+	if err := database.LockContext(ctx, locker); err != nil {
+		return err
+	}
+
+	if err := i.sync(ctx); err != nil {
+		_ = locker.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (i *Concept) unlock() error {
+	locker, ok := i.databaseDriver.(database.Locker)
+	if !ok || !locker.Locked() {
+		return nil
+	}
+
+	return locker.Unlock()
+}
+
+// LockStatus returns the current holder of the shared lock.
+func (i *Concept) LockStatus() (*database.Lock, error) {
+	manager, ok := i.databaseDriver.(database.LockManager)
+	if !ok || !manager.Lockable() {
+		return nil, errors.New("concept: database driver does not support lock management")
+	}
+
+	return manager.Holder()
+}
+
+// ReleaseLock frees the shared lock held by another process. Unless force is
+// set, the lock is only released when its holder stopped sending heartbeats.
+func (i *Concept) ReleaseLock(force bool) error {
+	manager, ok := i.databaseDriver.(database.LockManager)
+	if !ok || !manager.Lockable() {
+		return errors.New("concept: database driver does not support lock management")
+	}
+
+	return manager.Release(force)
+}
+
+func (i *Concept) rebuild(ctx context.Context) error {
 	for i.sourceDriver.Next() {
+		if err := ctx.Err(); err != nil {
+			i.latestErr = err
+			return err
+		}
+
 		mg, err := i.sourceDriver.Read()
 		if err != nil {
 			i.latestErr = err
@@ -279,40 +1038,242 @@ func (i *Concept) rebuild() error {
 		}
 	}
 
+	if !i.registryMerged {
+		for _, script := range i.registry.scripts() {
+			i.latestErr = i.appendScript(script)
+			if i.latestErr != nil {
+				return i.latestErr
+			}
+		}
+		i.registryMerged = true
+	}
+
 	if i.unpairedRevs > 0 {
-		unpairedRevs := make([]string, 0, i.unpairedRevs)
+		i.unpaired = make([]string, 0, i.unpairedRevs)
 		for _, version := range i.versions {
 			migration := i.migrations[version]
 			if migration.AdvanceScript == nil {
-				unpairedRevs = append(unpairedRevs, migration.ReverseScript.Identifier)
+				i.unpaired = append(i.unpaired, migration.ReverseScript.Identifier)
 			}
 		}
-		natsort.Sort(unpairedRevs)
-		return fmt.Errorf("concept: found %v rev without adv migration %v", i.unpairedRevs, unpairedRevs)
-	}
+		natsort.Sort(i.unpaired)
 
-	var histories []*database.History
-	histories, i.latestErr = i.databaseDriver.Read()
-	if i.latestErr != nil {
+		// states are still computed so that Validate can report the problem.
+		if err := i.sync(ctx); err != nil {
+			i.latestErr = err
+			return err
+		}
+
+		i.latestErr = fmt.Errorf("concept: found %v rev without adv migration %v", i.unpairedRevs, i.unpaired)
 		return i.latestErr
 	}
 
+	i.latestErr = i.sync(ctx)
+	return i.latestErr
+}
+
+// sync recomputes every migration state from the database history. Source
+// scripts are kept as they are, so it can be called again once another process
+// may have changed the history (e.g. after acquiring the shared lock).
+func (i *Concept) sync(ctx context.Context) error {
+	i.synced = false
+	i.mismatches = make(map[string]string, 0)
+
+	versions := i.versions[:0]
+	for _, version := range i.versions {
+		migration := i.migrations[version]
+		if migration.AdvanceScript == nil && migration.ReverseScript == nil {
+			delete(i.migrations, version)
+			continue
+		}
+
+		migration.State = pendingState
+		if migration.AdvanceScript == nil {
+			migration.State = unknownState
+		}
+		migration.AppliedBy = ""
+		migration.AppliedAt = 0
+		migration.ExecutionTime = 0
+		migration.Statements = 0
+		migration.OutOfOrder = false
+
+		versions = append(versions, version)
+	}
+	i.versions = versions
+
+	names := i.repeatableNames[:0]
+	for _, name := range i.repeatableNames {
+		migration := i.repeatables[name]
+		if migration.AdvanceScript == nil {
+			delete(i.repeatables, name)
+			continue
+		}
+
+		migration.State = pendingState
+		migration.AppliedBy = ""
+		migration.AppliedAt = 0
+		migration.ExecutionTime = 0
+		migration.Statements = 0
+
+		names = append(names, name)
+	}
+	i.repeatableNames = names
+	i.superseded = make(map[string][]*Migration, 0)
+	i.baselineVersion = ""
+
+	histories, err := database.ReadContext(ctx, i.databaseDriver)
+	if err != nil {
+		return err
+	}
+
+	histories, squash := i.squashed(histories)
 	for _, history := range histories {
-		// TODO: make databaseAppend independent from appendSource, so we can sync while appending from source
-		i.latestErr = i.databaseAppend(history)
-		if i.latestErr != nil {
-			return i.latestErr
+		if err = i.databaseAppend(history); err != nil {
+			return err
 		}
 	}
 
+	// the squash script holds the whole schema, it cannot be applied on top
+	// of some of the migrations it replaces.
+	if squash != nil && squash.State&(successState|baselineState) == 0 {
+		return fmt.Errorf("concept: database is behind the squashed version %v, apply the squashed migrations with a previous source first", squash.Version)
+	}
+
 	natsort.Sort(i.versions)
+	natsort.Sort(i.repeatableNames)
+
+	i.latestSourceVersion = ""
+	for c := len(i.versions) - 1; c >= 0 && i.latestSourceVersion == ""; c-- {
+		if mg := i.migrations[i.versions[c]]; mg.AdvanceScript != nil || mg.ReverseScript != nil {
+			i.latestSourceVersion = mg.Version
+		}
+	}
 
+	i.resolveBaseline()
+	i.resolveEnvironment()
+	i.resolveAvailability()
+	i.resolveOutOfOrder()
+	i.synced = true
+
+	if len(i.mismatches) > 0 {
+		versions := make([]string, 0, len(i.mismatches))
+		for version := range i.mismatches {
+			versions = append(versions, version)
+		}
+		natsort.Sort(versions)
+
+		return fmt.Errorf("concept: possibly wrong migration/database (mismatch migration description) %v", versions)
+	}
+
+	return nil
+}
+
+// squashed drops the history of the migrations replaced by the latest squash
+// script of the source. The entries of the squash version itself are kept as
+// entries of the squash script, a database that applied the replaced
+// migrations already satisfies it. When entries are dropped, the squash
+// migration is returned so the caller can check it ends up applied.
+func (i *Concept) squashed(histories []*database.History) ([]*database.History, *Migration) {
+	var squash *Migration
+	for _, version := range i.versions {
+		mg := i.migrations[version]
+		if mg.AdvanceScript != nil && mg.Description == SquashDescription {
+			squash = mg
+		}
+	}
+
+	if squash == nil {
+		return histories, nil
+	}
+
+	dropped := false
+	kept := make([]*database.History, 0, len(histories))
+	for _, history := range histories {
+		mode := Direction(history.Mode)
+		if mode == RepeatableDirection || mode == RepairDirection || natsort.Compare(squash.Version, history.Version) {
+			kept = append(kept, history)
+			continue
+		}
+
+		if history.Version != squash.Version {
+			dropped = true
+			continue
+		}
+
+		if history.Description != SquashDescription {
+			replaced := *history
+			replaced.Description = SquashDescription
+			replaced.Checksum = ""
+			history = &replaced
+			dropped = true
+		}
+		kept = append(kept, history)
+	}
+
+	if !dropped {
+		return kept, nil
+	}
+
+	return kept, squash
+}
+
+// resolveBaseline marks every migration never run at or below the baseline
+// version as part of the baseline.
+func (i *Concept) resolveBaseline() {
+	if i.baselineVersion == "" {
+		return
+	}
+
+	for _, version := range i.versions {
+		if natsort.Compare(i.baselineVersion, version) {
+			break
+		}
+
+		migration := i.migrations[version]
+		if migration.State == pendingState {
+			migration.State = baselineState
+		}
+	}
+}
+
+// resolveOutOfOrder marks the pending migrations found below the latest applied
+// one as ignored. They usually come from a branch merged after newer migrations
+// have been applied.
+func (i *Concept) resolveOutOfOrder() {
+	i.ignored = make([]string, 0)
+	i.latestDatabaseVersion = ""
+
+	for c := len(i.versions) - 1; c >= 0; c-- {
+		version := i.versions[c]
+		migration := i.migrations[version]
+
+		applied := migration.State&successState > 0 && migration.State&undoneState == 0
+		if (applied || migration.State&baselineState > 0) && i.latestDatabaseVersion == "" {
+			i.latestDatabaseVersion = version
+		}
+
+		excluded := migration.State&excludedState > 0
+		if i.latestDatabaseVersion != "" && migration.AdvanceScript != nil && migration.State&pendingState > 0 && !excluded {
+			migration.State |= ignoredState
+			i.ignored = append(i.ignored, version)
+		}
+	}
+
+	natsort.Sort(i.ignored)
+}
+
+// resolveAvailability makes sure that rollback never reaches below an applied
+// migration without reverse script.
+func (i *Concept) resolveAvailability() {
 	unavailable := false
 	for c := len(i.versions) - 1; c >= 0; c-- {
 		version := i.versions[c]
 		migration := i.migrations[version]
 
-		if (migration.State & availableState) == 0 {
+		// only applied migrations can block the way back, pending and undone
+		// ones are skipped by rollback anyway.
+		applied := migration.State&successState > 0 && migration.State&undoneState == 0
+		if applied && (migration.State&availableState) == 0 {
 			unavailable = true
 		}
 
@@ -320,11 +1281,21 @@ func (i *Concept) rebuild() error {
 			migration.State &^= availableState
 		}
 	}
-
-	return nil
 }
 
 func (i *Concept) databaseAppend(history *database.History) error {
+	if Direction(history.Mode) == RepairDirection {
+		return nil
+	}
+
+	if Direction(history.Mode) == RepeatableDirection {
+		return i.repeatableAppend(history)
+	}
+
+	if Direction(history.Mode) == BaselineDirection {
+		return i.baselineAppend(history)
+	}
+
 	item, exists := i.migrations[history.Version]
 	if !exists {
 		// we skip reverse migration
@@ -338,7 +1309,9 @@ func (i *Concept) databaseAppend(history *database.History) error {
 			AppliedBy:     history.AppliedBy,
 			AppliedAt:     history.AppliedAt,
 			ExecutionTime: history.ExecutionTime,
+			Statements:    history.Statements,
 			State:         failedState | missingState,
+			OutOfOrder:    Direction(history.Mode) == OutOfOrderDirection,
 		}
 
 		if history.Success {
@@ -351,13 +1324,31 @@ func (i *Concept) databaseAppend(history *database.History) error {
 		return nil
 	}
 
+	// sync refuses to go on once every entry is read, Validate reports them.
 	if item.Description != history.Description {
-		return fmt.Errorf("concept: possibly wrong migration/database (mismatch migration description)")
+		i.mismatches[item.Version] = history.Description
+	}
+
+	item.AppliedBy = history.AppliedBy
+	item.AppliedAt = history.AppliedAt
+	item.ExecutionTime = history.ExecutionTime
+	item.Statements = history.Statements
+
+	missing := item.State & missingState
+	item.OutOfOrder = false
+	if Direction(history.Mode) == ReverseDirection {
+		item.State = failedState | missing
+		if history.Success {
+			item.State = successState | undoneState | pendingState | missing
+		}
+
+		return nil
 	}
 
-	item.State = failedState
+	item.OutOfOrder = Direction(history.Mode) == OutOfOrderDirection
+	item.State = failedState | missing
 	if history.Success {
-		item.State = successState
+		item.State = successState | missing
 	}
 
 	// migration history only save checksum for the advance script. so here, we
@@ -368,18 +1359,130 @@ func (i *Concept) databaseAppend(history *database.History) error {
 		item.State |= futureState
 	}
 
-	if item.ReverseScript != nil {
+	if item.Reversible() {
 		item.State |= availableState
 	}
 
 	return nil
 }
 
+// baselineAppend records the baseline version, the baseline shows up as its
+// own migration when no source migration has that version.
+func (i *Concept) baselineAppend(history *database.History) error {
+	i.baselineVersion = history.Version
+
+	item, exists := i.migrations[history.Version]
+	if !exists {
+		item = &Migration{
+			Version:     history.Version,
+			Description: history.Description,
+		}
+
+		i.migrations[item.Version] = item
+		i.versions = append(i.versions, item.Version)
+	}
+
+	item.AppliedBy = history.AppliedBy
+	item.AppliedAt = history.AppliedAt
+	item.State = baselineState
+
+	return nil
+}
+
+// repeatableAppend applies a run of a repeatable migration, histories come in
+// rank order so every run replaces the one before.
+func (i *Concept) repeatableAppend(history *database.History) error {
+	item, exists := i.repeatables[history.Version]
+	if !exists {
+		item = &Migration{
+			Description: history.Version,
+			State:       missingState,
+			Repeatable:  true,
+		}
+
+		i.repeatables[item.Description] = item
+		i.repeatableNames = append(i.repeatableNames, item.Description)
+	}
+
+	if item.AppliedAt != 0 {
+		i.supersede(item)
+	}
+
+	item.AppliedBy = history.AppliedBy
+	item.AppliedAt = history.AppliedAt
+	item.ExecutionTime = history.ExecutionTime
+	item.Statements = history.Statements
+
+	missing := item.State & missingState
+	item.State = failedState | missing
+	if history.Success {
+		item.State = successState | missing
+	}
+
+	if item.AdvanceScript != nil && item.AdvanceScript.Checksum() != history.Checksum {
+		item.State |= outdatedState
+	}
+
+	return nil
+}
+
+// supersede keeps the current run of a repeatable migration aside, it is about
+// to be replaced by a newer one.
+func (i *Concept) supersede(item *Migration) {
+	previous := *item
+	previous.State = supersededState
+	previous.AdvanceScript = nil
+
+	i.superseded[item.Description] = append(i.superseded[item.Description], &previous)
+}
+
 func (i *Concept) appendSource(migration *source.Migration) error {
 	script, err := i.parse(migration.Identifier)
 	if err != nil {
 		return err
 	}
+	script.SetContent(migration.Script)
+
+	var reverse *Script
+	if script.Direction == AdvanceDirection {
+		if reverse, err = script.splitSections(); err != nil {
+			return err
+		}
+	}
+
+	if err = script.parseDirectives(); err != nil {
+		return err
+	}
+
+	if err = i.appendScript(script); err != nil || reverse == nil {
+		return err
+	}
+
+	if err = reverse.parseDirectives(); err != nil {
+		return err
+	}
+
+	return i.appendScript(reverse)
+}
+
+func (i *Concept) appendScript(script *Script) error {
+	if script.Direction == RepeatableDirection {
+		item, exists := i.repeatables[script.Description]
+		if exists {
+			return fmt.Errorf("concept: duplicate migration %v", script.Identifier)
+		}
+
+		item = &Migration{
+			Description:   script.Description,
+			State:         pendingState,
+			Repeatable:    true,
+			AdvanceScript: script,
+		}
+
+		i.repeatables[item.Description] = item
+		i.repeatableNames = append(i.repeatableNames, item.Description)
+		return nil
+	}
 
 	item, exists := i.migrations[script.Version]
 	if !exists {
@@ -433,6 +1536,14 @@ func (i *Concept) appendSource(migration *source.Migration) error {
 }
 
 func (i *Concept) parse(identifier string) (*Script, error) {
+	if matches := i.repeatablePattern.FindStringSubmatch(identifier); matches != nil {
+		return &Script{
+			Identifier:  identifier,
+			Description: matches[1],
+			Direction:   RepeatableDirection,
+		}, nil
+	}
+
 	matches := i.pattern.FindStringSubmatch(identifier)
 	if matches == nil {
 		return nil, errors.New("concept: encounter invalid migration identifier")
@@ -1 +1,1083 @@
 package concept
+
+import (
+	"context"
+	"errors"
+	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/source"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConcept(t *testing.T, dbPath, migrationPath string) *Concept {
+	con, err := New("sqlite://"+dbPath, "file://"+migrationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = con.databaseDriver.Close()
+	})
+
+	if err = con.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	return con
+}
+
+func writeMigration(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertState(t *testing.T, con *Concept, version string, expected state) {
+	t.Helper()
+
+	mg, exists := con.migrations[version]
+	if !exists {
+		t.Fatalf("migration %v does not exist", version)
+	}
+
+	if mg.State != expected {
+		t.Fatalf("migration %v state is [%v], expected [%v]", version, mg.State, expected)
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+	writeMigration(t, dir, "00002_create_posts.adv.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_create_posts.rev.sql", "DROP TABLE posts;")
+
+	con := newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", pendingState)
+	assertState(t, con, "00002", pendingState)
+
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState|availableState)
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", successState|availableState)
+	assertState(t, con, "00002", successState|availableState)
+
+	if err := con.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", successState|availableState)
+	assertState(t, con, "00002", successState|undoneState|pendingState)
+
+	if err := con.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := con.Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00001", successState|availableState)
+	assertState(t, con, "00002", successState|undoneState|pendingState)
+}
+
+func TestMigrateSteps(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00001", successState)
+	assertState(t, con, "00002", pendingState)
+
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00003", successState)
+}
+
+type writeFailDriver struct {
+	database.Driver
+	runs int
+}
+
+func (i *writeFailDriver) Write(*database.History) error {
+	return errors.New("write failed")
+}
+
+func (i *writeFailDriver) Run(migration io.Reader) error {
+	i.runs++
+	return i.Driver.Run(migration)
+}
+
+func TestMigrateWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	driver := &writeFailDriver{Driver: con.databaseDriver}
+	con.databaseDriver = driver
+
+	if err := con.Migrate(-1); err == nil || err.Error() != "write failed" {
+		t.Fatalf("expected write failure, got %v", err)
+	}
+
+	if driver.runs != 0 {
+		t.Fatal("expected the script not to run without its history entry")
+	}
+}
+
+func TestExecutionTime(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := con.Rollback(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	histories, err := con.databaseDriver.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(histories) != 2 {
+		t.Fatalf("expected 2 history entries, got %v", len(histories))
+	}
+
+	for _, history := range histories {
+		if history.ExecutionTime > 60000 {
+			t.Fatalf("unexpected %v execution time %vms", history.Mode, history.ExecutionTime)
+		}
+	}
+}
+
+func TestSetHooks(t *testing.T) {
+	con := &Concept{}
+	con.ClearHooks()
+	con.SetHooks(nil)
+
+	called := false
+	con.SetHooks(&Hooks{
+		PreMigrate: func(m *Migration) {
+			called = true
+		},
+	})
+
+	con.hooks.PreMigrate(nil)
+	con.hooks.PostMigrate(nil)
+	if !called {
+		t.Fatal("expected hook to be set")
+	}
+}
+
+func TestMigrateFailure(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_broken.sql", "CREATE TABLE posts (id integer PRIMARY KEY); CREATE TABLE;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", successState)
+	assertState(t, con, "00002", failedState)
+}
+
+func TestBatchTransaction(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_broken.sql", "CREATE TABLE;")
+
+	con := newTestConcept(t, dbPath, dir)
+	con.SetBatchTransaction(true)
+	if err := con.Migrate(-1); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+	assertState(t, con, "00001", pendingState)
+	assertState(t, con, "00002", failedState)
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", pendingState)
+	assertState(t, con, "00002", failedState)
+}
+
+func TestMigrateToRollbackTo(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.adv.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.rev.sql", "DROP TABLE tags;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.MigrateTo("99"); err == nil {
+		t.Fatal("expected unknown version to be refused")
+	}
+
+	targets, err := con.Targets(AdvanceDirection, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 2 || targets[0].Version != "00001" || targets[1].Version != "00002" {
+		t.Fatalf("unexpected targets %v", targets)
+	}
+
+	if err = con.MigrateTo("00002"); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState)
+	assertState(t, con, "00003", pendingState)
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = con.RollbackTo("00001"); err == nil {
+		t.Fatal("expected rollback over an irreversible migration to be refused")
+	}
+	assertState(t, con, "00003", successState|availableState)
+
+	if err = con.RollbackTo("00002"); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState)
+	assertState(t, con, "00003", successState|undoneState|pendingState)
+}
+
+func TestPlan(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	plan, err := con.Plan(AdvanceDirection, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != 2 || !plan[0].Reversible || plan[1].Reversible {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	sql, err := plan[0].SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sql != "CREATE TABLE users (id integer PRIMARY KEY);" {
+		t.Fatalf("unexpected plan sql %q", sql)
+	}
+
+	histories, err := con.databaseDriver.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(histories) != 0 {
+		t.Fatal("expected plan to leave the history untouched")
+	}
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = con.Plan(ReverseDirection, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != 0 {
+		t.Fatalf("expected nothing to revert, got %+v", plan)
+	}
+}
+
+func TestMigrateContextCancel(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_endless.sql", "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c;")
+
+	con := newTestConcept(t, dbPath, dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := con.MigrateContext(ctx, -1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", failedState)
+}
+
+func TestRepeatable(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY, name text);")
+	writeMigration(t, dir, "R__user_names.sql", "CREATE VIEW user_names AS SELECT name FROM users;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if con.repeatables["user_names"].State != successState {
+		t.Fatalf("unexpected repeatable state [%v]", con.repeatables["user_names"].State)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	targets, err := con.Targets(AdvanceDirection, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 0 {
+		t.Fatalf("expected unchanged repeatable to be skipped, got %v", targets)
+	}
+
+	writeMigration(t, dir, "R__user_names.sql", "DROP VIEW user_names; CREATE VIEW user_names AS SELECT id, name FROM users;")
+
+	con = newTestConcept(t, dbPath, dir)
+	if con.repeatables["user_names"].State != successState|outdatedState {
+		t.Fatalf("unexpected repeatable state [%v]", con.repeatables["user_names"].State)
+	}
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	migrations, err := con.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 3 || migrations[1].State != supersededState || migrations[2].State != successState {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00002", pendingState|ignoredState)
+
+	if err := con.Migrate(-1); err == nil {
+		t.Fatal("expected out of order migration to be refused")
+	}
+
+	con.SetOutOfOrder(true)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00002", successState)
+	if !con.migrations["00002"].OutOfOrder || con.migrations["00003"].OutOfOrder {
+		t.Fatal("expected only 00002 to be recorded as out of order")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.adv.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.rev.sql", "DROP TABLE tags;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.databaseDriver.Run(strings.NewReader("CREATE TABLE users (id integer PRIMARY KEY); CREATE TABLE posts (id integer PRIMARY KEY);")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := con.Baseline("2", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := con.Baseline("2", ""); err == nil {
+		t.Fatal("expected a second baseline to be refused")
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00001", baselineState)
+	assertState(t, con, "00002", baselineState)
+	assertState(t, con, "00003", pendingState)
+
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00003", successState|availableState)
+
+	if err := con.RollbackTo("00001"); err == nil {
+		t.Fatal("expected rollback into the baseline to be refused")
+	}
+
+	if err := con.RollbackTo("00002"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type dumpDriver struct {
+	database.Driver
+	schema string
+}
+
+func (i *dumpDriver) Dump(w io.Writer, opts database.DumpOptions) error {
+	_, err := io.WriteString(w, i.schema)
+	return err
+}
+
+func TestSquash(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+	behindPath := filepath.Join(t.TempDir(), "behind.db")
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+
+	if err := newTestConcept(t, behindPath, dir).Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newTestConcept(t, otherPath, dir).MigrateTo("2"); err != nil {
+		t.Fatal(err)
+	}
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.MigrateTo("2"); err != nil {
+		t.Fatal(err)
+	}
+
+	dbDrv, err := database.Open("sqlite://" + dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = dbDrv.Close()
+	})
+
+	scDrv, err := source.Open("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	con, err = NewWithInstance(&dumpDriver{
+		Driver: dbDrv,
+		schema: "CREATE TABLE users (id integer PRIMARY KEY);\nCREATE TABLE posts (id integer PRIMARY KEY);\n",
+	}, scDrv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = con.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = con.Squash("3"); err == nil {
+		t.Fatal("expected squash over a pending migration to be refused")
+	}
+
+	created, removed, err := con.Squash("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(created) != "00002_squash.sql" || len(removed) != 3 {
+		t.Fatalf("unexpected squash result %v %v", created, removed)
+	}
+	assertState(t, con, "00002", successState)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected squash and 00003 scripts only, got %v", entries)
+	}
+
+	for _, path := range []string{dbPath, otherPath} {
+		con = newTestConcept(t, path, dir)
+		if len(con.versions) != 2 {
+			t.Fatalf("expected squashed versions to be hidden, got %v", con.versions)
+		}
+		assertState(t, con, "00002", successState)
+		assertState(t, con, "00003", pendingState)
+	}
+
+	con = newTestConcept(t, filepath.Join(t.TempDir(), "fresh.db"), dir)
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00003", successState)
+
+	con, err = New("sqlite://"+behindPath, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.databaseDriver.Close()
+
+	if err = con.Refresh(); err == nil {
+		t.Fatal("expected a database behind the squash to be refused")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_broken.sql", "CREATE TABLE;")
+
+	con := newTestConcept(t, dbPath, dir)
+	current, err := con.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current.Version != "" || current.Pending != 3 {
+		t.Fatalf("unexpected schema version %+v", current)
+	}
+
+	if err = con.Migrate(-1); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	current, err = con.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current.Version != "00002" || current.Description != "create_posts" || current.Pending != 0 || current.Failed != 1 {
+		t.Fatalf("unexpected schema version %+v", current)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_tags.sql", "CREATE TABLE tags (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := con.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Valid() {
+		t.Fatalf("unexpected issues %v", report.Err())
+	}
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY, name text);")
+	writeMigration(t, dir, "00005_create_likes.sql", "CREATE TABLE likes (id integer PRIMARY KEY);")
+
+	con = newTestConcept(t, dbPath, dir)
+	con.SetValidateOnMigrate(true)
+	if err = con.Migrate(-1); err == nil {
+		t.Fatal("expected migrate to refuse a modified script")
+	}
+	assertState(t, con, "00005", pendingState)
+
+	if err = os.Rename(filepath.Join(dir, "00002_create_posts.sql"), filepath.Join(dir, "00002_create_articles.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "00003_create_tags.sql")); err != nil {
+		t.Fatal(err)
+	}
+	writeMigration(t, dir, "00004_create_comments.rev.sql", "DROP TABLE comments;")
+
+	con, err = New("sqlite://"+dbPath, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.databaseDriver.Close()
+
+	if err = con.Refresh(); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+
+	report, err = con.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make([]IssueKind, 0)
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+
+	expected := []IssueKind{ModifiedIssue, DescriptionIssue, MissingIssue, UnpairedIssue}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected issues %v, expected %v", kinds, expected)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00002_broken.sql", "CREATE TABLE posts (id integer PRIMARY KEY); CREATE TABLE;")
+
+	con := newTestConcept(t, dbPath, dir)
+	if err := con.Migrate(-1); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+
+	unsynced, err := New("sqlite://"+dbPath, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsynced.databaseDriver.Close()
+
+	if _, err = unsynced.RepairPlan(); err == nil || !strings.Contains(err.Error(), "refreshed") {
+		t.Fatalf("expected repair plan to require a refresh, got %v", err)
+	}
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY, name text);")
+	writeMigration(t, dir, "00002_broken.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+
+	con = newTestConcept(t, dbPath, dir)
+	actions, err := con.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make([]RepairKind, 0)
+	for _, action := range actions {
+		kinds = append(kinds, action.Kind)
+	}
+
+	expected := []RepairKind{RealignRepair, RemoveFailedRepair}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected repairs %v, expected %v", kinds, expected)
+	}
+
+	assertState(t, con, "00001", successState)
+	assertState(t, con, "00002", pendingState)
+
+	report, err := con.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("unexpected issues %v", report.Err())
+	}
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00002", successState)
+
+	if actions, err = con.RepairPlan(); err != nil || len(actions) > 0 {
+		t.Fatalf("expected nothing to repair, got %v (%v)", len(actions), err)
+	}
+}
+
+func TestGoMigration(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY, name text);")
+	writeMigration(t, dir, "00003_create_posts.sql", "CREATE TABLE posts (id integer PRIMARY KEY);")
+
+	registry := NewRegistry()
+	err := registry.Register(&GoMigration{
+		Version:     "00002",
+		Description: "seed_users",
+		Advance: func(ctx context.Context, db database.Executor) error {
+			_, err := db.ExecContext(ctx, "INSERT INTO users (name) VALUES (?), (?)", "alice", "bob")
+			return err
+		},
+		Reverse: func(ctx context.Context, db database.Executor) error {
+			_, err := db.ExecContext(ctx, "DELETE FROM users")
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = registry.Register(&GoMigration{Version: "00002", Advance: func(context.Context, database.Executor) error { return nil }}); err == nil {
+		t.Fatal("expected duplicate go migration to be refused")
+	}
+
+	newConcept := func() *Concept {
+		con, err := New("sqlite://"+dbPath, "file://"+dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = con.databaseDriver.Close()
+		})
+
+		con.SetRegistry(registry)
+		if err = con.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		return con
+	}
+
+	con := newConcept()
+	if err = con.MigrateTo("00002"); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState|availableState)
+	assertState(t, con, "00003", pendingState)
+
+	countUsers := func() int {
+		var count int
+		db := con.databaseDriver.(database.Handler).Handle()
+		if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	if count := countUsers(); count != 2 {
+		t.Fatalf("expected 2 users, got %v", count)
+	}
+
+	con = newConcept()
+	report, err := con.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Fatalf("unexpected issues %v", report.Err())
+	}
+
+	if err = con.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState|undoneState|pendingState)
+
+	if count := countUsers(); count != 0 {
+		t.Fatalf("expected no users, got %v", count)
+	}
+}
+
+func TestStatementProgress(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE users (id integer PRIMARY KEY);\nINSERT INTO users VALUES (1), (2), (3);")
+	writeMigration(t, dir, "00002_broken.sql", "-- concept:no-transaction\nCREATE TABLE posts (id integer PRIMARY KEY);\nCREATE TABLE tags (id integer PRIMARY KEY);\nCREATE TABLE;")
+
+	con := newTestConcept(t, dbPath, dir)
+
+	events := make(map[string][]*database.StatementEvent)
+	con.SetHooks(&Hooks{
+		Statement: func(m *Migration, event *database.StatementEvent) {
+			events[m.Version] = append(events[m.Version], event)
+		},
+	})
+
+	if err := con.Migrate(-1); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+
+	if len(events["00001"]) != 2 || events["00001"][1].RowsAffected != 3 {
+		t.Fatalf("unexpected events %+v", events["00001"])
+	}
+
+	if len(events["00002"]) != 3 || events["00002"][2].Err == nil || events["00002"][2].Line != 4 {
+		t.Fatalf("unexpected events %+v", events["00002"])
+	}
+
+	con = newTestConcept(t, dbPath, dir)
+	assertState(t, con, "00002", failedState)
+
+	if statements := con.migrations["00001"].Statements; statements != 2 {
+		t.Fatalf("expected 2 statements recorded for 00001, got %v", statements)
+	}
+
+	if statements := con.migrations["00002"].Statements; statements != 2 {
+		t.Fatalf("expected 2 statements recorded for 00002, got %v", statements)
+	}
+}
+
+func TestStatementsRolledBack(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		dir := t.TempDir()
+		dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+		writeMigration(t, dir, "00001_broken.sql", "CREATE TABLE posts (id integer PRIMARY KEY);\nCREATE TABLE;")
+
+		con := newTestConcept(t, dbPath, dir)
+		con.SetBatchTransaction(batch)
+		if err := con.Migrate(-1); err == nil {
+			t.Fatal("expected migration to fail")
+		}
+
+		if statements := con.migrations["00001"].Statements; statements != 0 {
+			t.Fatalf("expected no statement left behind by a rolled back script, got %v", statements)
+		}
+
+		con = newTestConcept(t, dbPath, dir)
+		assertState(t, con, "00001", failedState)
+
+		actions, err := con.RepairPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(actions) != 1 || strings.Contains(actions[0].Message, "succeeded") {
+			t.Fatalf("unexpected actions %+v", actions)
+		}
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.adv.sql", "CREATE TABLE users (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00001_create_users.rev.sql", "DROP TABLE users;")
+	writeMigration(t, dir, "00002_seed_users.sql", "-- seed data\n-- concept:env=staging, development\nINSERT INTO users VALUES (1);")
+	writeMigration(t, dir, "00003_create_posts.adv.sql", "-- concept:irreversible\n-- concept:timeout=1m\nCREATE TABLE posts (id integer PRIMARY KEY);")
+	writeMigration(t, dir, "00003_create_posts.rev.sql", "DROP TABLE posts;")
+	writeMigration(t, dir, "00004_create_tags.sql", "-- concept:requires=2\n-- concept:no-transaction\nCREATE TABLE tags (id integer PRIMARY KEY);")
+
+	newConcept := func(environment string) *Concept {
+		con, err := New("sqlite://"+dbPath, "file://"+dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = con.databaseDriver.Close()
+		})
+
+		con.SetEnvironment(environment)
+		if err = con.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		return con
+	}
+
+	con := newConcept("production")
+	directives := con.migrations["00003"].AdvanceScript.Directives
+	if !directives.Irreversible || directives.Timeout != time.Minute {
+		t.Fatalf("unexpected directives %+v", directives)
+	}
+
+	if err := con.MigrateTo("00003"); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", pendingState|excludedState)
+	assertState(t, con, "00003", successState)
+
+	if con.migrations["00002"].Pending() {
+		t.Fatal("expected excluded migration not to be pending")
+	}
+
+	if err := con.Migrate(-1); err == nil || !strings.Contains(err.Error(), "requires version 00002") {
+		t.Fatalf("expected unmet requirement, got %v", err)
+	}
+
+	if err := con.RollbackTo("00001"); err == nil {
+		t.Fatal("expected irreversible migration to block rollback")
+	}
+
+	con = newConcept("staging")
+	con.SetOutOfOrder(true)
+	con.SetBatchTransaction(true)
+	if err := con.Migrate(-1); err == nil || !strings.Contains(err.Error(), "no-transaction") {
+		t.Fatalf("expected no-transaction script to refuse batch, got %v", err)
+	}
+
+	con.SetBatchTransaction(false)
+	if err := con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState)
+	assertState(t, con, "00004", successState)
+
+	writeMigration(t, dir, "00005_broken.sql", "-- concept:timeout=soon\nSELECT 1;")
+	con, err := New("sqlite://"+dbPath, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.databaseDriver.Close()
+
+	if err = con.Refresh(); err == nil || !strings.Contains(err.Error(), "invalid directive") {
+		t.Fatalf("expected invalid directive, got %v", err)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "CREATE TABLE ${table} (id integer PRIMARY KEY, note text DEFAULT '$${kept}');")
+	writeMigration(t, dir, "00002_create_posts.sql", "CREATE TABLE ${Posts.Table} (id integer PRIMARY KEY);")
+
+	con := newTestConcept(t, dbPath, dir)
+	con.SetPlaceholders(map[string]string{"table": "users"})
+
+	if err := con.Migrate(-1); err == nil || !strings.Contains(err.Error(), "undefined placeholders [Posts.Table]") {
+		t.Fatalf("expected undefined placeholder, got %v", err)
+	}
+	assertState(t, con, "00001", pendingState)
+
+	t.Setenv(PlaceholderEnvPrefix+"POSTS_TABLE", "posts")
+	plan, err := con.Plan(AdvanceDirection, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql, err := plan[0].SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sql != "CREATE TABLE users (id integer PRIMARY KEY, note text DEFAULT '${kept}');" {
+		t.Fatalf("unexpected plan sql %q", sql)
+	}
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, con, "00002", successState)
+
+	// checksums are computed on the raw scripts, other values do not change
+	// them.
+	con = newTestConcept(t, dbPath, dir)
+	con.SetPlaceholders(map[string]string{"table": "members"})
+	report, err := con.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Valid() {
+		t.Fatalf("expected valid history, got %+v", report)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "concept.db")
+
+	writeMigration(t, dir, "00001_create_users.sql", "-- +concept Up\nCREATE TABLE users (id integer PRIMARY KEY);\n\n-- +concept Down\nDROP TABLE users;\n")
+	writeMigration(t, dir, "00002_create_posts.sql", "-- +concept Up\n-- concept:irreversible\nCREATE TABLE posts (id integer PRIMARY KEY);\n-- +concept Down\nDROP TABLE posts;\n")
+
+	con := newTestConcept(t, dbPath, dir)
+	if !con.migrations["00001"].Reversible() || con.migrations["00002"].Reversible() {
+		t.Fatal("expected only the migration with a usable down section to be reversible")
+	}
+
+	down, err := con.migrations["00001"].ReverseScript.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(down) != "-- +concept Down\nDROP TABLE users;\n" {
+		t.Fatalf("unexpected down section %q", down)
+	}
+
+	created, err := con.CreateSingleFile("create_tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created != "00003_create_tags.sql" {
+		t.Fatalf("unexpected created migration %v", created)
+	}
+
+	writeMigration(t, dir, "00004_create_tags.sql", "-- +concept Up\nCREATE TABLE tags (id integer PRIMARY KEY);\n-- +concept Down\n-- tags are kept\n/* on purpose */\n")
+
+	con = newTestConcept(t, dbPath, dir)
+	if con.migrations["00003"].Reversible() || con.migrations["00004"].Reversible() {
+		t.Fatal("expected migrations with an empty down section not to be reversible")
+	}
+
+	if err = con.Migrate(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = con.RollbackTo("00002"); err == nil || !strings.Contains(err.Error(), "00004 is not reversible") {
+		t.Fatalf("expected rollback of an empty down section to be refused, got %v", err)
+	}
+	assertState(t, con, "00003", successState)
+	assertState(t, con, "00004", successState)
+
+	writeMigration(t, dir, "00005_broken.sql", "-- +concept Down\nDROP TABLE tags;\n")
+	con, err = New("sqlite://"+dbPath, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.databaseDriver.Close()
+
+	if err = con.Refresh(); err == nil || !strings.Contains(err.Error(), "without an up section") {
+		t.Fatalf("expected missing up section, got %v", err)
+	}
+}
@@ -1,10 +1,14 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	nurl "net/url"
+	"path"
+	"time"
 )
 
 type OpenFunc func(url string) (Driver, error)
@@ -22,6 +26,10 @@ type History struct {
 	AppliedAt     uint64
 	ExecutionTime uint32
 	Success       bool
+
+	// Statements is the number of statements of the script that succeeded,
+	// zero when the driver does not run scripts statement by statement.
+	Statements uint32
 }
 
 type Driver interface {
@@ -29,15 +37,113 @@ type Driver interface {
 	Name() string
 
 	Close() error
+
+	// Read returns, ordered by rank, the latest history entry of every
+	// version, mode and checksum.
 	Read() ([]*History, error)
 	Write(*History) error
 	Run(migration io.Reader) error
 	Purge() []error
 }
 
+// HistoryDeleter is implemented by drivers able to remove history entries,
+// e.g. to repair the history after a failed migration.
+type HistoryDeleter interface {
+	// Delete removes the history entry with the rank of history.
+	Delete(history *History) error
+}
+
+// ContextDriver is implemented by drivers able to cancel their work through a
+// context, e.g. to interrupt a long-running script.
+type ContextDriver interface {
+	ReadContext(ctx context.Context) ([]*History, error)
+	WriteContext(ctx context.Context, history *History) error
+	RunContext(ctx context.Context, migration io.Reader) error
+}
+
+// ReadContext reads the history with ctx when the driver supports it, otherwise
+// it falls back to Read.
+func ReadContext(ctx context.Context, driver Driver) ([]*History, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if ctxDriver, ok := driver.(ContextDriver); ok {
+		return ctxDriver.ReadContext(ctx)
+	}
+
+	return driver.Read()
+}
+
+// WriteContext writes history with ctx when the driver supports it, otherwise
+// it falls back to Write.
+func WriteContext(ctx context.Context, driver Driver, history *History) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctxDriver, ok := driver.(ContextDriver); ok {
+		return ctxDriver.WriteContext(ctx, history)
+	}
+
+	return driver.Write(history)
+}
+
+// RunContext runs migration with ctx when the driver supports it, otherwise it
+// falls back to Run.
+func RunContext(ctx context.Context, driver Driver, migration io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctxDriver, ok := driver.(ContextDriver); ok {
+		return ctxDriver.RunContext(ctx, migration)
+	}
+
+	return driver.Run(migration)
+}
+
+// StatementEvent reports a single statement run by a StatementRunner.
+type StatementEvent struct {
+	// Index is the position of the statement in the script, starting at 1.
+	Index int
+	Line  int
+
+	Duration time.Duration
+
+	// RowsAffected is -1 when the driver cannot tell.
+	RowsAffected int64
+
+	// Err is the error of the statement, the script stops at the first one.
+	Err error
+}
+
+// StatementRunner is implemented by drivers running scripts one statement at a
+// time. progress, when not nil, is called after each statement.
+type StatementRunner interface {
+	RunStatements(ctx context.Context, migration io.Reader, progress func(event *StatementEvent)) error
+}
+
+// RunStatementsContext runs migration statement by statement when the driver
+// supports it, otherwise it falls back to RunContext and progress is never
+// called.
+func RunStatementsContext(ctx context.Context, driver Driver, migration io.Reader, progress func(event *StatementEvent)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if runner, ok := driver.(StatementRunner); ok {
+		return runner.RunStatements(ctx, migration, progress)
+	}
+
+	return RunContext(ctx, driver, migration)
+}
+
 type Locker interface {
-	Lock()
-	Unlock()
+	// Lock acquires the shared lock, waiting until it is released by its
+	// current holder. It returns an error when the wait timeout is exceeded.
+	Lock() error
+	Unlock() error
 
 	// Locked returns current shared lock status
 	Locked() bool
@@ -46,6 +152,175 @@ type Locker interface {
 	Lockable() bool
 }
 
+// ContextLocker is implemented by lockers able to stop waiting for the shared
+// lock when a context is done.
+type ContextLocker interface {
+	LockContext(ctx context.Context) error
+}
+
+// LockContext acquires the shared lock with ctx when the locker supports it,
+// otherwise it falls back to Lock.
+func LockContext(ctx context.Context, locker Locker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctxLocker, ok := locker.(ContextLocker); ok {
+		return ctxLocker.LockContext(ctx)
+	}
+
+	return locker.Lock()
+}
+
+// Transactor is implemented by drivers able to run scripts and history writes
+// inside a transaction. Between Begin and Commit/Rollback, every Run and Write
+// call takes part in the same transaction.
+type Transactor interface {
+	Begin() error
+	Commit() error
+	Rollback() error
+
+	// TransactionalDDL returns nil when schema changes are part of the
+	// transaction, otherwise an error explaining why they are not.
+	TransactionalDDL() error
+}
+
+// Executor runs statements against the database, both *sql.DB and *sql.Tx
+// satisfy it.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Handler is implemented by drivers exposing their database handle, e.g. to
+// run migrations written in Go.
+type Handler interface {
+	// Handle returns the running transaction, or the database handle when
+	// there is none.
+	Handle() Executor
+}
+
+// Lock describes the current holder of a shared lock. Timestamps are unix
+// seconds, zero when unknown.
+type Lock struct {
+	Locked           bool
+	Owner            string
+	Host             string
+	PID              int
+	ProcessStartedAt uint64
+	LockedAt         uint64
+	HeartbeatAt      uint64
+
+	// Stale is true when the holder stopped refreshing its heartbeat and the
+	// lock can be taken over.
+	Stale bool
+}
+
+// LockManager is implemented by lockers that are able to inspect and release a
+// lock held by another process.
+type LockManager interface {
+	Locker
+
+	// Holder returns the current lock holder.
+	Holder() (*Lock, error)
+
+	// Release frees the lock regardless of which process is holding it. Unless
+	// force is set, only a stale lock is released.
+	Release(force bool) error
+}
+
+// DumpOptions tunes what Dump writes besides the schema.
+type DumpOptions struct {
+	// DataTables lists the tables whose rows are dumped along with the schema.
+	DataTables []string
+}
+
+// Dumper is implemented by drivers able to export the current schema as a
+// script recreating it. The output is deterministic, so dumping an unchanged
+// database twice gives the same script. History and locking tables are left
+// out.
+type Dumper interface {
+	Dump(w io.Writer, opts DumpOptions) error
+}
+
+type ObjectType string
+
+const (
+	ObjectTable     ObjectType = "table"
+	ObjectView      ObjectType = "view"
+	ObjectProcedure ObjectType = "procedure"
+	ObjectFunction  ObjectType = "function"
+	ObjectTrigger   ObjectType = "trigger"
+	ObjectEvent     ObjectType = "event"
+
+	// ObjectSequence, ObjectDomain and ObjectCustomType are only found in
+	// postgres.
+	ObjectSequence   ObjectType = "sequence"
+	ObjectDomain     ObjectType = "domain"
+	ObjectCustomType ObjectType = "type"
+)
+
+// Object is a database object that can be dropped by a Cleaner.
+type Object struct {
+	Type ObjectType
+	Name string
+}
+
+// ObjectError reports an object that could not be dropped.
+type ObjectError struct {
+	Object Object
+	Err    error
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("drop %v %v: %v", e.Object.Type, e.Object.Name, e.Err)
+}
+
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+// CleanOptions selects the objects listed by Cleaner.Objects.
+type CleanOptions struct {
+	// Types lists the object types to select, every type when empty.
+	Types []ObjectType
+
+	// Exclude lists the names, or path.Match patterns, of objects that are
+	// never selected.
+	Exclude []string
+}
+
+// Selects returns true when object matches the options.
+func (o CleanOptions) Selects(object Object) bool {
+	selected := len(o.Types) == 0
+	for _, objectType := range o.Types {
+		if objectType == object.Type {
+			selected = true
+			break
+		}
+	}
+
+	for _, pattern := range o.Exclude {
+		if matched, _ := path.Match(pattern, object.Name); matched || pattern == object.Name {
+			return false
+		}
+	}
+
+	return selected
+}
+
+// Cleaner is implemented by drivers able to drop a selection of objects
+// instead of purging the whole database.
+type Cleaner interface {
+	// Objects lists, in drop order, the objects selected by opts.
+	Objects(opts CleanOptions) ([]Object, error)
+
+	// Clean drops the given objects. Every object that could not be dropped
+	// is reported by its own ObjectError, the others are still dropped.
+	Clean(objects []Object) []error
+}
+
 func Open(url string) (Driver, error) {
 	purl, err := nurl.Parse(url)
 	if err != nil {
@@ -56,17 +331,19 @@ func Open(url string) (Driver, error) {
 		return nil, errors.New("database driver: url must include scheme as driver name")
 	}
 
-	if !purl.Query().Has("x-history-table") {
-		purl.Query().Set("x-history-table", "migration_history")
+	query := purl.Query()
+	if !query.Has("x-history-table") {
+		query.Set("x-history-table", "migration_history")
 	}
 
-	if !purl.Query().Has("x-locking-table") {
-		purl.Query().Set("x-locking-table", "migration_locking")
+	if !query.Has("x-locking-table") {
+		query.Set("x-locking-table", "migration_locking")
 	}
 
-	if !purl.Query().Has("x-without-locking") {
-		purl.Query().Del("x-locking-table")
+	if query.Has("x-without-locking") {
+		query.Del("x-locking-table")
 	}
+	purl.RawQuery = query.Encode()
 
 	openFunc, exists := drivers[purl.Scheme]
 	if !exists {
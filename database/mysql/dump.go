@@ -0,0 +1,486 @@
+package mysql
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var _ database.Dumper = (*MySQL)(nil)
+
+var (
+	// the next auto increment value and the definer depend on the database the
+	// dump is taken from, they are left out to keep the dump reproducible.
+	autoIncrementPattern = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+	definerPattern       = regexp.MustCompile(`DEFINER=\S+\s`)
+
+	numericTypes = map[string]bool{
+		"TINYINT":   true,
+		"SMALLINT":  true,
+		"MEDIUMINT": true,
+		"INT":       true,
+		"BIGINT":    true,
+		"DECIMAL":   true,
+		"FLOAT":     true,
+		"DOUBLE":    true,
+		"YEAR":      true,
+	}
+
+	binaryTypes = map[string]bool{
+		"BINARY":     true,
+		"VARBINARY":  true,
+		"TINYBLOB":   true,
+		"BLOB":       true,
+		"MEDIUMBLOB": true,
+		"LONGBLOB":   true,
+		"BIT":        true,
+		"GEOMETRY":   true,
+	}
+
+	stringEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		`'`, `\'`,
+		"\x00", `\0`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\x1a", `\Z`,
+	)
+)
+
+// Dump writes tables (in foreign key order), the rows of opts.DataTables,
+// functions and procedures, views (in dependency order) and triggers. Every
+// object type is sorted by name otherwise. Statements end with a plain
+// semicolon, compound statement bodies are parsed by the server.
+func (i *MySQL) Dump(w io.Writer, opts database.DumpOptions) error {
+	tables, views, err := i.dumpTableNames()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		known[table] = true
+	}
+
+	withData := make(map[string]bool, len(opts.DataTables))
+	for _, table := range opts.DataTables {
+		if !known[table] {
+			return fmt.Errorf("mysql: cannot dump rows of unknown table %v", table)
+		}
+		withData[table] = true
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "SET FOREIGN_KEY_CHECKS = 0;")
+
+	tables, err = i.sortTables(tables)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		statement, err := i.showCreate("SHOW CREATE TABLE "+quoteIdentifier(table), "Create Table")
+		if err != nil {
+			return err
+		}
+		writeStatement(out, autoIncrementPattern.ReplaceAllString(statement, ""))
+	}
+
+	for _, table := range tables {
+		if !withData[table] {
+			continue
+		}
+
+		if err = i.dumpRows(out, table); err != nil {
+			return err
+		}
+	}
+
+	// views may call functions, routines are created first. Routine bodies are
+	// only resolved when they are called.
+	if err = i.dumpRoutines(out); err != nil {
+		return err
+	}
+
+	if err = i.dumpViews(out, views); err != nil {
+		return err
+	}
+
+	if err = i.dumpTriggers(out); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "SET FOREIGN_KEY_CHECKS = 1;")
+	return out.Flush()
+}
+
+// dumpTableNames returns the base tables and views of the current database,
+// sorted by name.
+func (i *MySQL) dumpTableNames() ([]string, []string, error) {
+	query := "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME"
+	rows, err := i.db.Query(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	tables := make([]string, 0)
+	views := make([]string, 0)
+
+	for rows.Next() {
+		var tableName string
+		var tableType string
+
+		if err := rows.Scan(&tableName, &tableType); err != nil {
+			return nil, nil, err
+		}
+
+		if tableName == i.historyTable || tableName == i.lockingTable {
+			continue
+		}
+
+		if tableType == "BASE TABLE" {
+			tables = append(tables, tableName)
+		}
+
+		if tableType == "VIEW" {
+			views = append(views, tableName)
+		}
+	}
+
+	// information_schema collation may not sort the way Go does.
+	sort.Strings(tables)
+	sort.Strings(views)
+
+	return tables, views, rows.Err()
+}
+
+// sortTables orders tables so that referenced tables come before the tables
+// referencing them.
+func (i *MySQL) sortTables(tables []string) ([]string, error) {
+	query := "SELECT DISTINCT TABLE_NAME, REFERENCED_TABLE_NAME FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL"
+	rows, err := i.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dependencies := make(map[string][]string)
+	for rows.Next() {
+		var table, referenced string
+		if err := rows.Scan(&table, &referenced); err != nil {
+			return nil, err
+		}
+
+		dependencies[table] = append(dependencies[table], referenced)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortByDependency(tables, dependencies), nil
+}
+
+func (i *MySQL) dumpRows(out io.Writer, table string) error {
+	order, err := i.rowOrder(table)
+	if err != nil {
+		return err
+	}
+
+	rows, err := i.db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY %s", quoteIdentifier(table), order))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, quoteIdentifier(column.Name()))
+	}
+
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", quoteIdentifier(table), strings.Join(names, ", "))
+	values := make([]sql.RawBytes, len(columns))
+	pointers := make([]any, len(columns))
+	for c := range values {
+		pointers[c] = &values[c]
+	}
+
+	fmt.Fprintln(out)
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		literals := make([]string, 0, len(values))
+		for c, value := range values {
+			literals = append(literals, dumpValue(value, columns[c].DatabaseTypeName()))
+		}
+
+		fmt.Fprintln(out, prefix+strings.Join(literals, ", ")+");")
+	}
+
+	return rows.Err()
+}
+
+// rowOrder sorts rows by primary key, or by every column when the table has
+// none, so that rows are always dumped in the same order.
+func (i *MySQL) rowOrder(table string) (string, error) {
+	query := "SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY ORDINAL_POSITION"
+	rows, err := i.db.Query(query, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns := make([]string, 0)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return "", err
+		}
+
+		columns = append(columns, quoteIdentifier(column))
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(columns) > 0 {
+		return strings.Join(columns, ", "), nil
+	}
+
+	var count int
+	query = "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+	if err = i.db.QueryRow(query, table).Scan(&count); err != nil {
+		return "", err
+	}
+
+	for c := 1; c <= count; c++ {
+		columns = append(columns, fmt.Sprint(c))
+	}
+
+	return strings.Join(columns, ", "), nil
+}
+
+func (i *MySQL) dumpRoutines(out io.Writer) error {
+	query := "SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() ORDER BY ROUTINE_TYPE, ROUTINE_NAME"
+	rows, err := i.db.Query(query)
+	if err != nil {
+		return err
+	}
+
+	routines := make([][2]string, 0)
+	for rows.Next() {
+		var routine [2]string
+		if err := rows.Scan(&routine[0], &routine[1]); err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		routines = append(routines, routine)
+	}
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, routine := range routines {
+		// routine type is either FUNCTION or PROCEDURE.
+		kind := routine[0]
+		column := "Create " + kind[:1] + strings.ToLower(kind[1:])
+
+		statement, err := i.showCreate(fmt.Sprintf("SHOW CREATE %s %s", kind, quoteIdentifier(routine[1])), column)
+		if err != nil {
+			return err
+		}
+		writeStatement(out, definerPattern.ReplaceAllString(statement, ""))
+	}
+
+	return nil
+}
+
+func (i *MySQL) dumpViews(out io.Writer, views []string) error {
+	statements := make(map[string]string, len(views))
+	for _, view := range views {
+		statement, err := i.showCreate("SHOW CREATE VIEW "+quoteIdentifier(view), "Create View")
+		if err != nil {
+			return err
+		}
+		statements[view] = definerPattern.ReplaceAllString(statement, "")
+	}
+
+	// information_schema only lists view dependencies since MySQL 8.0.13, the
+	// definitions are searched for other view names instead.
+	dependencies := make(map[string][]string)
+	for _, view := range views {
+		for _, other := range views {
+			if other != view && strings.Contains(statements[view], quoteIdentifier(other)) {
+				dependencies[view] = append(dependencies[view], other)
+			}
+		}
+	}
+
+	for _, view := range sortByDependency(views, dependencies) {
+		writeStatement(out, statements[view])
+	}
+
+	return nil
+}
+
+func (i *MySQL) dumpTriggers(out io.Writer) error {
+	query := "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = DATABASE() ORDER BY EVENT_OBJECT_TABLE, ACTION_ORDER, TRIGGER_NAME"
+	rows, err := i.db.Query(query)
+	if err != nil {
+		return err
+	}
+
+	triggers := make([]string, 0)
+	for rows.Next() {
+		var trigger string
+		if err := rows.Scan(&trigger); err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		triggers = append(triggers, trigger)
+	}
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, trigger := range triggers {
+		statement, err := i.showCreate("SHOW CREATE TRIGGER "+quoteIdentifier(trigger), "SQL Original Statement")
+		if err != nil {
+			return err
+		}
+		writeStatement(out, definerPattern.ReplaceAllString(statement, ""))
+	}
+
+	return nil
+}
+
+// showCreate runs a SHOW CREATE statement and returns the given column of its
+// single row.
+func (i *MySQL) showCreate(query, column string) (string, error) {
+	rows, err := i.db.Query(query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("mysql: %v returned nothing", query)
+	}
+
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]any, len(columns))
+	for c := range values {
+		pointers[c] = &values[c]
+	}
+
+	if err = rows.Scan(pointers...); err != nil {
+		return "", err
+	}
+
+	for c, name := range columns {
+		if name != column {
+			continue
+		}
+
+		// definitions are hidden from users lacking privileges on the object.
+		if !values[c].Valid {
+			return "", fmt.Errorf("mysql: %v returned no definition, check the user privileges", query)
+		}
+
+		return values[c].String, nil
+	}
+
+	return "", fmt.Errorf("mysql: %v has no %v column", query, column)
+}
+
+func writeStatement(out io.Writer, statement string) {
+	fmt.Fprintf(out, "\n%s;\n", strings.TrimRight(statement, "; \n"))
+}
+
+// sortByDependency orders names so that every name comes after its
+// dependencies, ties keep the order of names. Cycles are broken by keeping the
+// first name reached.
+func sortByDependency(names []string, dependencies map[string][]string) []string {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	visited := make(map[string]bool, len(names))
+
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		deps := append([]string(nil), dependencies[name]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if known[dep] {
+				visit(dep)
+			}
+		}
+
+		sorted = append(sorted, name)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return sorted
+}
+
+// dumpValue formats a column value as a literal, value is nil for NULL.
+func dumpValue(value sql.RawBytes, typeName string) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	typeName = strings.TrimPrefix(typeName, "UNSIGNED ")
+	if numericTypes[typeName] {
+		return string(value)
+	}
+
+	if binaryTypes[typeName] {
+		return fmt.Sprintf("X'%x'", []byte(value))
+	}
+
+	return "'" + stringEscaper.Replace(string(value)) + "'"
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
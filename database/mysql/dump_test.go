@@ -0,0 +1,41 @@
+package mysql
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestSortByDependency(t *testing.T) {
+	names := []string{"comments", "posts", "tags", "users"}
+	dependencies := map[string][]string{
+		"comments": {"posts", "users"},
+		"posts":    {"users", "posts"},
+		"tags":     {"missing"},
+	}
+
+	sorted := sortByDependency(names, dependencies)
+	expected := []string{"users", "posts", "comments", "tags"}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Fatalf("unexpected order %v, expected %v", sorted, expected)
+	}
+}
+
+func TestDumpValue(t *testing.T) {
+	cases := []struct {
+		value    sql.RawBytes
+		typeName string
+		expected string
+	}{
+		{nil, "VARCHAR", "NULL"},
+		{sql.RawBytes("42"), "UNSIGNED BIGINT", "42"},
+		{sql.RawBytes("it's\n"), "TEXT", `'it\'s\n'`},
+		{sql.RawBytes{0x01, 0xff}, "VARBINARY", "X'01ff'"},
+	}
+
+	for _, c := range cases {
+		if actual := dumpValue(c.value, c.typeName); actual != c.expected {
+			t.Fatalf("dumpValue(%q, %v) = %v, expected %v", c.value, c.typeName, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package mysql
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"math"
+	"os"
+	"time"
+)
+
+var (
+	_ database.LockManager   = (*MySQL)(nil)
+	_ database.ContextLocker = (*MySQL)(nil)
+)
+
+const (
+	// LockingModeAdvisory uses the server-wide GET_LOCK/RELEASE_LOCK named lock.
+	LockingModeAdvisory = "advisory"
+
+	// LockingModeTable uses a row in the locking table as the lock.
+	LockingModeTable = "table"
+
+	DefaultLockTimeout       = 30 * time.Second
+	DefaultHeartbeatInterval = 10 * time.Second
+	DefaultLockExpiry        = time.Minute
+
+	lockPollInterval = time.Second
+)
+
+// processStartedAt identifies this process together with its pid, pids alone
+// are reused by the operating system.
+var processStartedAt = time.Now()
+
+var ErrLockHeld = errors.New("mysql: migration lock is held by an active process")
+
+// ErrLockLost is returned by the scripts running once the lock was released or
+// taken over by another process.
+var ErrLockLost = errors.New("mysql: migration lock was released or taken over by another process")
+
+func (i *MySQL) Lock() error {
+	return i.LockContext(context.Background())
+}
+
+// LockContext is like Lock, waiting for the lock stops when ctx is done.
+func (i *MySQL) LockContext(ctx context.Context) error {
+	if i.locked {
+		return nil
+	}
+
+	if err := i.prepareLockingTable(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	if i.lockingMode == LockingModeTable {
+		err = i.tableLock(ctx)
+	} else {
+		err = i.advisoryLock(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	i.locked = true
+	i.startHeartbeat()
+	return nil
+}
+
+func (i *MySQL) Unlock() error {
+	if !i.locked {
+		return nil
+	}
+
+	i.stopHeartbeat()
+
+	query := fmt.Sprintf("UPDATE `%s` SET `is_locked` = 0 WHERE `id` = 1 AND `locked_by` = ? AND `locked_at` = ?", i.lockingTable)
+	_, err := i.db.Exec(query, i.lockOwner(), i.lockedAt)
+
+	if i.lockConn != nil {
+		var released sql.NullInt64
+		if releaseErr := i.lockConn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", i.lockName).Scan(&released); err == nil {
+			err = releaseErr
+		}
+
+		if closeErr := i.lockConn.Close(); err == nil {
+			err = closeErr
+		}
+		i.lockConn = nil
+	}
+
+	i.locked = false
+	return err
+}
+
+// Locked returns current shared lock status
+func (i *MySQL) Locked() bool {
+	return i.locked
+}
+
+// Lockable returns true if shared lock is enabled
+func (i *MySQL) Lockable() bool {
+	return i.lockingTable != ""
+}
+
+func (i *MySQL) Holder() (*database.Lock, error) {
+	exists, err := i.lockingTableExists(false)
+	if err != nil || !exists {
+		return &database.Lock{}, err
+	}
+
+	var lockedBy, host sql.NullString
+	var lockedAt, pid, processStarted, heartbeatAt sql.NullInt64
+	lock := &database.Lock{}
+
+	query := fmt.Sprintf("SELECT `is_locked`, `locked_by`, `locked_at`, `host`, `pid`, `process_started_at`, `heartbeat_at` FROM `%s` WHERE `id` = 1", i.lockingTable)
+	err = i.db.QueryRow(query).Scan(&lock.Locked, &lockedBy, &lockedAt, &host, &pid, &processStarted, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Owner = lockedBy.String
+	lock.LockedAt = uint64(lockedAt.Int64)
+	lock.Host = host.String
+	lock.PID = int(pid.Int64)
+	lock.ProcessStartedAt = uint64(processStarted.Int64)
+	lock.HeartbeatAt = uint64(heartbeatAt.Int64)
+
+	if i.lockingMode == LockingModeAdvisory {
+		// the server frees named locks as soon as the holder session is gone, the
+		// locking table may still say otherwise after a crash.
+		name, err := i.advisoryLockName()
+		if err != nil {
+			return nil, err
+		}
+
+		var connectionId sql.NullInt64
+		if err = i.db.QueryRow("SELECT IS_USED_LOCK(?)", name).Scan(&connectionId); err != nil {
+			return nil, err
+		}
+		lock.Locked = connectionId.Valid
+	}
+
+	lock.Stale = lock.Locked && i.expired(lock.HeartbeatAt)
+	return lock, nil
+}
+
+func (i *MySQL) Release(force bool) error {
+	lock, err := i.Holder()
+	if err != nil {
+		return err
+	}
+
+	if !lock.Locked {
+		return nil
+	}
+
+	if !lock.Stale && !force {
+		return ErrLockHeld
+	}
+
+	if i.lockingMode == LockingModeAdvisory {
+		// a named lock can only be released by its own session, it is freed by
+		// the server once that session ends.
+		name, err := i.advisoryLockName()
+		if err != nil {
+			return err
+		}
+
+		var connectionId sql.NullInt64
+		if err = i.db.QueryRow("SELECT IS_USED_LOCK(?)", name).Scan(&connectionId); err != nil {
+			return err
+		}
+
+		if connectionId.Valid {
+			return fmt.Errorf("mysql: migration lock is held by connection %d, it is freed once that session ends", connectionId.Int64)
+		}
+	}
+
+	// the holder, if still alive, finds out with its next heartbeat and stops.
+	query := fmt.Sprintf("UPDATE `%s` SET `is_locked` = 0 WHERE `id` = 1", i.lockingTable)
+	_, err = i.db.Exec(query)
+	return err
+}
+
+func (i *MySQL) advisoryLock(ctx context.Context) error {
+	name, err := i.advisoryLockName()
+	if err != nil {
+		return err
+	}
+
+	// named locks belong to the session that acquired them, so the connection
+	// is pinned until the lock is released.
+	conn, err := i.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the driver closes the connection when ctx is done, which ends the wait
+	// of GET_LOCK on the server.
+	var acquired sql.NullInt64
+	timeout := int(math.Ceil(i.lockTimeout.Seconds()))
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, timeout).Scan(&acquired); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if !acquired.Valid || acquired.Int64 != 1 {
+		_ = conn.Close()
+		return i.lockTimeoutError()
+	}
+
+	i.lockConn = conn
+	i.lockName = name
+
+	// the named lock is the real guard, the locking table only records who is
+	// holding it.
+	now := time.Now()
+	query := fmt.Sprintf("UPDATE `%s` SET `is_locked` = 1, %s WHERE `id` = 1", i.lockingTable, lockOwnerColumns)
+	if _, err = i.db.ExecContext(ctx, query, i.lockOwnerArgs(now)...); err != nil {
+		i.locked = true
+		_ = i.Unlock()
+		return err
+	}
+
+	i.lockedAt = now.Unix()
+	return nil
+}
+
+func (i *MySQL) tableLock(ctx context.Context) error {
+	deadline := time.Now().Add(i.lockTimeout)
+	query := fmt.Sprintf(
+		"UPDATE `%s` SET `is_locked` = 1, %s WHERE `id` = 1 AND (`is_locked` = 0 OR `heartbeat_at` IS NULL OR `heartbeat_at` < ?)",
+		i.lockingTable,
+		lockOwnerColumns,
+	)
+
+	for {
+		// a holder that stopped sending heartbeats is considered dead, its lock
+		// is taken over.
+		now := time.Now()
+		expiredAt := now.Add(-i.lockExpiry).Unix()
+		res, err := i.db.ExecContext(ctx, query, append(i.lockOwnerArgs(now), expiredAt)...)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 1 {
+			i.lockedAt = now.Unix()
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return i.lockTimeoutError()
+		}
+
+		if remaining > lockPollInterval {
+			remaining = lockPollInterval
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (i *MySQL) startHeartbeat() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	lost := make(chan struct{})
+	i.heartbeatStop = stop
+	i.heartbeatDone = done
+	i.lockLost = lost
+
+	condition := "`id` = 1 AND `is_locked` = 1 AND `locked_by` = ? AND `locked_at` = ?"
+	query := fmt.Sprintf("UPDATE `%s` SET `heartbeat_at` = ? WHERE %s", i.lockingTable, condition)
+	check := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE %s", i.lockingTable, condition)
+	owner, lockedAt := i.lockOwner(), i.lockedAt
+
+	// with advisory locking the named lock stays the guard, whatever happens
+	// to the locking table.
+	guarded := i.lockingMode == LockingModeTable
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(i.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				// a missed beat is not fatal, the next one may succeed before the
+				// lock expires.
+				res, err := i.db.Exec(query, now.Unix(), owner, lockedAt)
+				if err != nil || !guarded {
+					continue
+				}
+
+				if affected, err := res.RowsAffected(); err != nil || affected > 0 {
+					continue
+				}
+
+				// rows left unchanged are not counted, the row may still be ours.
+				var held int
+				if err = i.db.QueryRow(check, owner, lockedAt).Scan(&held); err == nil && held == 0 {
+					close(lost)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// lockGuard returns ctx, which is also done once the heartbeat finds out the
+// lock was lost.
+func (i *MySQL) lockGuard(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if i.lockLost == nil {
+		return ctx, cancel
+	}
+
+	go func(lost chan struct{}) {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}(i.lockLost)
+
+	return ctx, cancel
+}
+
+func (i *MySQL) lockLostErr() error {
+	if i.lockLost == nil {
+		return nil
+	}
+
+	select {
+	case <-i.lockLost:
+		return ErrLockLost
+	default:
+		return nil
+	}
+}
+
+func (i *MySQL) stopHeartbeat() {
+	if i.heartbeatStop == nil {
+		return
+	}
+
+	close(i.heartbeatStop)
+	<-i.heartbeatDone
+	i.heartbeatStop = nil
+	i.heartbeatDone = nil
+	i.lockLost = nil
+}
+
+func (i *MySQL) expired(heartbeatAt uint64) bool {
+	return time.Since(time.Unix(int64(heartbeatAt), 0)) > i.lockExpiry
+}
+
+func (i *MySQL) prepareLockingTable(ctx context.Context) error {
+	if _, err := i.lockingTableExists(true); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT IGNORE INTO `%s` (`id`, `is_locked`) VALUES (1, 0)", i.lockingTable)
+	_, err := i.db.ExecContext(ctx, query)
+	return err
+}
+
+func (i *MySQL) lockTimeoutError() error {
+	lock, err := i.Holder()
+	if err != nil || lock.Owner == "" {
+		return fmt.Errorf("mysql: could not acquire migration lock within %v", i.lockTimeout)
+	}
+
+	return fmt.Errorf(
+		"mysql: could not acquire migration lock within %v, held by %s since %s",
+		i.lockTimeout,
+		lock.Owner,
+		time.Unix(int64(lock.LockedAt), 0).Format(time.RFC3339),
+	)
+}
+
+// advisoryLockName scopes the named lock to the current database, named locks
+// are shared by the whole server and limited to 64 characters.
+func (i *MySQL) advisoryLockName() (string, error) {
+	var schema sql.NullString
+	if err := i.db.QueryRow("SELECT DATABASE()").Scan(&schema); err != nil {
+		return "", err
+	}
+
+	if !schema.Valid {
+		return "", errors.New("mysql: advisory locking requires a selected database")
+	}
+
+	name := schema.String + "." + i.lockingTable
+	if len(name) > 64 {
+		name = fmt.Sprintf("%x", md5.Sum([]byte(name)))
+	}
+
+	return name, nil
+}
+
+const lockOwnerColumns = "`locked_by` = ?, `locked_at` = ?, `host` = ?, `pid` = ?, `process_started_at` = ?, `heartbeat_at` = ?"
+
+func (i *MySQL) lockOwnerArgs(now time.Time) []any {
+	return []any{i.lockOwner(), now.Unix(), hostname(), os.Getpid(), processStartedAt.Unix(), now.Unix()}
+}
+
+func (i *MySQL) lockOwner() string {
+	return fmt.Sprintf("%s:%d", hostname(), os.Getpid())
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "-"
+	}
+
+	return name
+}
@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openLockers returns two drivers sharing the same locking table, as two
+// processes would.
+func openLockers(t *testing.T, params string) (*MySQL, *MySQL) {
+	first := openTest(t, params)
+	if errs := first.Purge(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	second := openTest(t, params)
+	t.Cleanup(func() {
+		_ = second.Unlock()
+		_ = first.Unlock()
+	})
+
+	return first, second
+}
+
+func testLockContext(t *testing.T, params string) {
+	first, second := openLockers(t, params)
+
+	if err := first.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	startTime := time.Now()
+	if err := second.LockContext(ctx); err == nil {
+		t.Fatal("expected held lock not to be acquired")
+	}
+
+	if elapsed := time.Since(startTime); elapsed > 5*time.Second {
+		t.Fatalf("expected the wait to stop with its context, took %v", elapsed)
+	}
+
+	if second.Locked() {
+		t.Fatal("expected the lock not to be acquired")
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := first.Holder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lock.Locked || lock.Stale {
+		t.Fatalf("unexpected holder %+v", lock)
+	}
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	testLockContext(t, "x-locking-mode=advisory&x-lock-timeout=30s")
+}
+
+func TestTableLock(t *testing.T) {
+	testLockContext(t, "x-locking-mode=table&x-lock-timeout=30s")
+}
+
+func TestHeartbeatExpiry(t *testing.T) {
+	first, second := openLockers(t, "x-locking-mode=table&x-lock-timeout=10s&x-lock-heartbeat=1s&x-lock-expiry=2s")
+
+	if err := first.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hung holder stops sending heartbeats, its lock expires.
+	first.stopHeartbeat()
+
+	startTime := time.Now()
+	if err := second.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(startTime); elapsed < time.Second {
+		t.Fatalf("expected the lock to be taken over once expired, took %v", elapsed)
+	}
+
+	lock, err := second.Holder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lock.Locked || lock.Stale {
+		t.Fatalf("unexpected holder %+v", lock)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	holder, other := openLockers(t, "x-locking-mode=table&x-lock-heartbeat=1s&x-lock-expiry=1m")
+
+	if err := holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Release(false); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expected alive holder to keep the lock, got %v", err)
+	}
+
+	if err := other.Release(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the holder is left running, its next heartbeat tells it the lock is gone.
+	deadline := time.Now().Add(5 * time.Second)
+	for holder.lockLostErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the holder to notice the released lock")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := holder.Run(strings.NewReader("SELECT 1;")); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected the holder to stop running scripts, got %v", err)
+	}
+
+	if err := other.Lock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseAdvisory(t *testing.T) {
+	holder, other := openLockers(t, "x-locking-mode=advisory")
+
+	if err := holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session of the holder is left alone, so is its named lock.
+	if err := other.Release(true); err == nil || !strings.Contains(err.Error(), "freed once that session ends") {
+		t.Fatalf("expected the named lock to stay held, got %v", err)
+	}
+
+	if err := holder.Run(strings.NewReader("SELECT 1;")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := holder.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Release(true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,13 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
+	"errors"
 	"fmt"
 	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/database/splitter"
 	"github.com/go-sql-driver/mysql"
 	"io"
 	nurl "net/url"
@@ -13,6 +16,12 @@ import (
 )
 
 var _ database.Driver = (*MySQL)(nil)
+var _ database.Transactor = (*MySQL)(nil)
+var _ database.ContextDriver = (*MySQL)(nil)
+var _ database.Cleaner = (*MySQL)(nil)
+var _ database.HistoryDeleter = (*MySQL)(nil)
+var _ database.Handler = (*MySQL)(nil)
+var _ database.StatementRunner = (*MySQL)(nil)
 
 //go:embed shistory.sql
 var sHistoryScript string
@@ -23,17 +32,47 @@ var sLockingScript string
 type Config struct {
 	HistoryTable string
 	LockingTable string
+
+	// LockingMode is either LockingModeAdvisory (default) or LockingModeTable.
+	LockingMode string
+
+	// LockTimeout is how long Lock waits for the current holder before giving
+	// up, DefaultLockTimeout is used when it is zero.
+	LockTimeout time.Duration
+
+	// HeartbeatInterval is how often the lock holder proves it is still alive,
+	// DefaultHeartbeatInterval is used when it is zero.
+	HeartbeatInterval time.Duration
+
+	// LockExpiry is how long a lock survives without heartbeat before it can be
+	// taken over, DefaultLockExpiry is used when it is zero.
+	LockExpiry time.Duration
 }
 
 type MySQL struct {
 	db *sql.DB
+	tx *sql.Tx
 
 	historyTable string
 	lockingTable string
-
-	booted    bool
-	tUsername string
-	rows      *sql.Rows
+	lockingMode  string
+	lockTimeout  time.Duration
+
+	heartbeatInterval time.Duration
+	lockExpiry        time.Duration
+
+	locked        bool
+	lockedAt      int64
+	lockName      string
+	lockConn      *sql.Conn
+	lockLost      chan struct{}
+	heartbeatStop chan struct{}
+	heartbeatDone chan struct{}
+
+	booted       bool
+	historyReady bool
+	tUsername    string
+	rows         *sql.Rows
 }
 
 func Open(url string) (database.Driver, error) {
@@ -70,9 +109,29 @@ func Open(url string) (database.Driver, error) {
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(10)
 
+	durations := map[string]time.Duration{
+		"x-lock-timeout":   0,
+		"x-lock-heartbeat": 0,
+		"x-lock-expiry":    0,
+	}
+	for key := range durations {
+		if !purl.Query().Has(key) {
+			continue
+		}
+
+		durations[key], err = time.ParseDuration(purl.Query().Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("mysql: invalid %v: %w", key, err)
+		}
+	}
+
 	return WithInstance(db, Config{
-		HistoryTable: purl.Query().Get("x-history-table"),
-		LockingTable: purl.Query().Get("x-locking-table"),
+		HistoryTable:      purl.Query().Get("x-history-table"),
+		LockingTable:      purl.Query().Get("x-locking-table"),
+		LockingMode:       purl.Query().Get("x-locking-mode"),
+		LockTimeout:       durations["x-lock-timeout"],
+		HeartbeatInterval: durations["x-lock-heartbeat"],
+		LockExpiry:        durations["x-lock-expiry"],
 	})
 }
 
@@ -81,11 +140,40 @@ func WithInstance(inst *sql.DB, cfg Config) (database.Driver, error) {
 		cfg.HistoryTable = "migration_history"
 	}
 
+	if cfg.LockingMode == "" {
+		cfg.LockingMode = LockingModeAdvisory
+	}
+
+	if cfg.LockingMode != LockingModeAdvisory && cfg.LockingMode != LockingModeTable {
+		return nil, fmt.Errorf("mysql: unknown locking mode %v", cfg.LockingMode)
+	}
+
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = DefaultLockTimeout
+	}
+
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if cfg.LockExpiry <= 0 {
+		cfg.LockExpiry = DefaultLockExpiry
+	}
+
+	if cfg.LockExpiry <= cfg.HeartbeatInterval {
+		return nil, errors.New("mysql: lock expiry must be longer than the heartbeat interval")
+	}
+
 	return &MySQL{
 		db:           inst,
 		historyTable: cfg.HistoryTable,
 		lockingTable: cfg.LockingTable,
+		lockingMode:  cfg.LockingMode,
+		lockTimeout:  cfg.LockTimeout,
 		booted:       true,
+
+		heartbeatInterval: cfg.HeartbeatInterval,
+		lockExpiry:        cfg.LockExpiry,
 	}, nil
 }
 
@@ -98,19 +186,32 @@ func (i *MySQL) Close() error {
 }
 
 func (i *MySQL) Read() ([]*database.History, error) {
-	if _, err := i.historyTableExists(true); err != nil {
+	return i.ReadContext(context.Background())
+}
+
+func (i *MySQL) ReadContext(ctx context.Context) ([]*database.History, error) {
+	exists, err := i.historyTableExists(false)
+	if err != nil {
 		return nil, err
 	}
 
+	// reading must not leave anything behind, the table is created by the
+	// first write.
+	if !exists {
+		return make([]*database.History, 0), nil
+	}
+	i.historyReady = true
+
 	query := fmt.Sprintf(
-		"SELECT * FROM `%s` AS `h1` WHERE `h1`.`rank` = (SELECT MAX(`h2`.`rank`) FROM `%s` AS `h2` WHERE `h2`.`version` = `h1`.`version` AND `h2`.`mode` = `h1`.`mode`)",
+		"SELECT * FROM `%s` AS `h1` WHERE `h1`.`rank` = (SELECT MAX(`h2`.`rank`) FROM `%s` AS `h2` WHERE `h2`.`version` = `h1`.`version` AND `h2`.`mode` = `h1`.`mode` AND `h2`.`checksum` = `h1`.`checksum`) ORDER BY `h1`.`rank`",
 		i.historyTable,
 		i.historyTable,
 	)
-	rows, err := i.db.Query(query)
+	rows, err := i.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	res := make([]*database.History, 0)
 
@@ -128,6 +229,7 @@ func (i *MySQL) Read() ([]*database.History, error) {
 			&row.AppliedAt,
 			&row.ExecutionTime,
 			&row.Success,
+			&row.Statements,
 		)
 		if err != nil {
 			return nil, err
@@ -136,23 +238,34 @@ func (i *MySQL) Read() ([]*database.History, error) {
 		res = append(res, &row)
 	}
 
-	return res, nil
+	return res, rows.Err()
 }
 
 func (i *MySQL) Write(history *database.History) error {
+	return i.WriteContext(context.Background(), history)
+}
+
+func (i *MySQL) WriteContext(ctx context.Context, history *database.History) error {
+	if !i.historyReady {
+		if _, err := i.historyTableExists(true); err != nil {
+			return err
+		}
+		i.historyReady = true
+	}
+
 	if history.AppliedBy == "" {
 		history.AppliedBy = i.username()
 	}
 
 	var res sql.Result
 	var insertedRank any = nil
-	query := fmt.Sprintf("INSERT INTO `%s` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", i.historyTable)
+	query := fmt.Sprintf("INSERT INTO `%s` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", i.historyTable)
 	if history.Rank > 0 {
-		query = fmt.Sprintf("REPLACE INTO `%s` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", i.historyTable)
+		query = fmt.Sprintf("REPLACE INTO `%s` VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", i.historyTable)
 		insertedRank = int64(history.Rank)
 	}
 
-	res, err := i.db.Exec(
+	res, err := i.conn().ExecContext(ctx,
 		query,
 		insertedRank,
 		history.Mode,
@@ -164,6 +277,7 @@ func (i *MySQL) Write(history *database.History) error {
 		history.AppliedAt,
 		history.ExecutionTime,
 		history.Success,
+		history.Statements,
 	)
 	if err != nil {
 		return err
@@ -184,129 +298,247 @@ func (i *MySQL) Write(history *database.History) error {
 	return nil
 }
 
+func (i *MySQL) Delete(history *database.History) error {
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE `rank` = ?", i.historyTable)
+	_, err := i.conn().Exec(query, history.Rank)
+	return err
+}
+
 func (i *MySQL) Run(migration io.Reader) error {
+	return i.RunContext(context.Background(), migration)
+}
+
+func (i *MySQL) RunContext(ctx context.Context, migration io.Reader) error {
+	return i.RunStatements(ctx, migration, nil)
+}
+
+// RunStatements runs the statements of migration one by one, DELIMITER
+// commands included.
+func (i *MySQL) RunStatements(ctx context.Context, migration io.Reader, progress func(event *database.StatementEvent)) error {
 	mg, err := io.ReadAll(migration)
 	if err != nil {
 		return err
 	}
 
-	query := string(mg)
-
-	_, err = i.db.Exec(query)
-	return err
-}
+	statements, err := splitter.Split(string(mg), splitter.MySQL)
+	if err != nil {
+		return err
+	}
 
-func (i *MySQL) Purge() []error {
-	errorItems := make([]error, 0)
+	ctx, cancel := i.lockGuard(ctx)
+	defer cancel()
 
-	if err := i.purgeTables(); err != nil {
-		errorItems = append(errorItems, err)
-		fmt.Println(err)
+	if i.tx != nil {
+		err = splitter.Run(ctx, i.tx, statements, progress)
+	} else {
+		err = i.runOnConn(ctx, statements, progress)
 	}
 
-	if err := i.purgeStoredProcedures(); err != nil {
-		errorItems = append(errorItems, err)
-		fmt.Println(err)
+	if lostErr := i.lockLostErr(); lostErr != nil {
+		return lostErr
 	}
 
-	return errorItems
+	return err
 }
 
-func (i *MySQL) purgeTables() error {
-	query := "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()"
-	rows, err := i.db.Query(query)
+// runOnConn runs statements on a single connection, they share the session,
+// e.g. variables or temporary tables.
+func (i *MySQL) runOnConn(ctx context.Context, statements []*splitter.Statement, progress func(event *database.StatementEvent)) error {
+	conn, err := i.db.Conn(ctx)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	quoteName := func(name string) string {
-		return "`" + name + "`"
-	}
+	return splitter.Run(ctx, conn, statements, progress)
+}
 
-	tables := make([]string, 0)
-	views := make([]string, 0)
+// Purge drops every object of the current database, history and locking
+// tables included.
+func (i *MySQL) Purge() []error {
+	objects, err := i.Objects(database.CleanOptions{})
+	if err != nil {
+		return []error{err}
+	}
 
-	for rows.Next() {
-		var tableName string
-		var tableType string
+	return i.Clean(objects)
+}
 
-		if err := rows.Scan(&tableName, &tableType); err != nil {
-			return err
+// Objects lists events and triggers first, then views, routines and tables.
+// Objects of the same type are sorted by name.
+func (i *MySQL) Objects(opts database.CleanOptions) ([]database.Object, error) {
+	queries := []struct {
+		objectType database.ObjectType
+		query      string
+	}{
+		{database.ObjectEvent, "SELECT EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = DATABASE() ORDER BY EVENT_NAME"},
+		{database.ObjectTrigger, "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = DATABASE() ORDER BY TRIGGER_NAME"},
+		{database.ObjectView, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'VIEW' ORDER BY TABLE_NAME"},
+		{database.ObjectProcedure, "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'PROCEDURE' ORDER BY ROUTINE_NAME"},
+		{database.ObjectFunction, "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'FUNCTION' ORDER BY ROUTINE_NAME"},
+		{database.ObjectTable, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"},
+	}
+
+	objects := make([]database.Object, 0)
+	for _, item := range queries {
+		rows, err := i.db.Query(item.query)
+		if err != nil {
+			return nil, err
 		}
 
-		if tableType == "BASE TABLE" {
-			tables = append(tables, quoteName(tableName))
+		for rows.Next() {
+			object := database.Object{Type: item.objectType}
+			if err := rows.Scan(&object.Name); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+
+			if opts.Selects(object) {
+				objects = append(objects, object)
+			}
 		}
+		_ = rows.Close()
 
-		if tableType == "VIEW" {
-			views = append(views, quoteName(tableName))
+		if err = rows.Err(); err != nil {
+			return nil, err
 		}
 	}
 
-	if _, err = i.db.Exec("SET foreign_key_checks = 0"); err != nil {
-		return err
+	return objects, nil
+}
+
+func (i *MySQL) Clean(objects []database.Object) []error {
+	errorItems := make([]error, 0)
+
+	// foreign_key_checks is a session variable, every drop must go through the
+	// same connection.
+	conn, err := i.db.Conn(context.Background())
+	if err != nil {
+		return append(errorItems, err)
 	}
+	defer conn.Close()
 
-	if len(tables) > 0 {
-		query = "DROP TABLE IF EXISTS " + strings.Join(tables, ", ")
-		if _, err = i.db.Exec(query); err != nil {
-			return err
-		}
+	if _, err = conn.ExecContext(context.Background(), "SET foreign_key_checks = 0"); err != nil {
+		return append(errorItems, err)
 	}
 
-	if len(tables) > 0 {
-		query = "DROP VIEW IF EXISTS " + strings.Join(views, ", ")
-		if _, err = i.db.Exec(query); err != nil {
-			return err
+	for _, object := range objects {
+		query := fmt.Sprintf("DROP %s IF EXISTS %s", strings.ToUpper(string(object.Type)), quoteIdentifier(object.Name))
+		if _, err = conn.ExecContext(context.Background(), query); err != nil {
+			errorItems = append(errorItems, &database.ObjectError{Object: object, Err: err})
+			continue
+		}
+
+		if object.Type == database.ObjectTable && object.Name == i.historyTable {
+			i.historyReady = false
 		}
 	}
 
-	if _, err = i.db.Exec("SET foreign_key_checks = 1"); err != nil {
-		return err
+	if _, err = conn.ExecContext(context.Background(), "SET foreign_key_checks = 1"); err != nil {
+		errorItems = append(errorItems, err)
 	}
 
-	return nil
+	return errorItems
 }
 
-func (i *MySQL) purgeStoredProcedures() error {
-	query := "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_TYPE = 'PROCEDURE'"
-	rows, err := i.db.Query(query)
+// executor is satisfied by both *sql.DB and *sql.Tx.
+type executor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn returns the running transaction, if any, or the database handle.
+func (i *MySQL) conn() executor {
+	if i.tx != nil {
+		return i.tx
+	}
+
+	return i.db
+}
+
+// Handle returns the running transaction, or the database handle when there is
+// none.
+func (i *MySQL) Handle() database.Executor {
+	return i.conn()
+}
+
+func (i *MySQL) Begin() error {
+	if i.tx != nil {
+		return errors.New("mysql: transaction already started")
+	}
+
+	tx, err := i.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	quoteName := func(name string) string {
-		return "`" + name + "`"
+	i.tx = tx
+	return nil
+}
+
+func (i *MySQL) Commit() error {
+	if i.tx == nil {
+		return errors.New("mysql: no transaction started")
 	}
 
-	items := make([]string, 0)
+	err := i.tx.Commit()
+	i.tx = nil
+	return err
+}
 
-	for rows.Next() {
-		var name string
+func (i *MySQL) Rollback() error {
+	if i.tx == nil {
+		return errors.New("mysql: no transaction started")
+	}
 
-		if err := rows.Scan(&name); err != nil {
-			return err
-		}
+	// the history table may have been created inside the transaction.
+	err := i.tx.Rollback()
+	i.tx = nil
+	i.historyReady = false
+	return err
+}
+
+// ErrImplicitCommit is returned by TransactionalDDL, MySQL commits the running
+// transaction before and after every DDL statement.
+var ErrImplicitCommit = errors.New("mysql: DDL statements cause an implicit commit, schema changes cannot be rolled back")
 
-		items = append(items, quoteName(name))
+func (i *MySQL) TransactionalDDL() error {
+	return ErrImplicitCommit
+}
+
+func (i *MySQL) historyTableExists(create bool) (bool, error) {
+	script := ""
+	if create {
+		script = fmt.Sprintf(sHistoryScript, i.historyTable)
 	}
 
-	for _, procedure := range items {
-		query = "DROP PROCEDURE IF EXISTS " + quoteName(procedure)
-		if _, err = i.db.Exec(query); err != nil {
-			return err
-		}
+	exists, err := i.tableExists(i.historyTable, script)
+	if err != nil || !exists || i.historyReady {
+		return exists, err
 	}
 
-	return nil
+	return true, i.upgradeHistoryTable()
 }
 
-func (i *MySQL) historyTableExists(create bool) (bool, error) {
-	if create {
-		return i.tableExists(i.historyTable, fmt.Sprintf(sHistoryScript, i.historyTable))
+// upgradeHistoryTable adds the columns missing from history tables created by
+// older versions.
+func (i *MySQL) upgradeHistoryTable() error {
+	exists := false
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'statements')"
+	if err := i.db.QueryRow(query, i.historyTable).Scan(&exists); err != nil {
+		return err
 	}
 
-	return i.tableExists(i.historyTable, "")
+	if exists {
+		return nil
+	}
+
+	_, err := i.db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `statements` int UNSIGNED NOT NULL DEFAULT 0", i.historyTable))
+	return err
 }
 
 func (i *MySQL) lockingTableExists(create bool) (bool, error) {
@@ -345,7 +577,7 @@ func (i *MySQL) username() string {
 	}
 
 	query := "SELECT CURRENT_USER()"
-	if err := i.db.QueryRow(query).Scan(i.tUsername); err != nil {
+	if err := i.db.QueryRow(query).Scan(&i.tUsername); err != nil {
 		i.tUsername = "-"
 	}
 
@@ -9,6 +9,7 @@ CREATE TABLE `%s` (
     `applied_at`        bigint UNSIGNED     NOT NULL,
     `execution_time`    int                 NOT NULL    DEFAULT 0,
     `success`           tinyint(1)          NOT NULL    DEFAULT 0,
+    `statements`        int UNSIGNED        NOT NULL    DEFAULT 0,
     PRIMARY KEY(`rank`),
     INDEX (`mode`, `version`)
 )
@@ -1,5 +1,11 @@
-CREATE TABLE `%s` (
+CREATE TABLE IF NOT EXISTS `%s` (
+    `id`            tinyint UNSIGNED    NOT NULL,
     `is_locked`     tinyint(1)          NOT NULL,
     `locked_by`     varchar(255)        NULL,
-    `locked_at`     bigint UNSIGNED     NULL
+    `locked_at`     bigint UNSIGNED     NULL,
+    `host`          varchar(255)        NULL,
+    `pid`           int UNSIGNED        NULL,
+    `process_started_at` bigint UNSIGNED NULL,
+    `heartbeat_at`  bigint UNSIGNED     NULL,
+    PRIMARY KEY(`id`)
 )
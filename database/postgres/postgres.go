@@ -0,0 +1,515 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/database/splitter"
+	"github.com/lib/pq"
+	"io"
+	nurl "net/url"
+	"strings"
+	"time"
+)
+
+var _ database.Driver = (*Postgres)(nil)
+var _ database.Transactor = (*Postgres)(nil)
+var _ database.ContextDriver = (*Postgres)(nil)
+var _ database.HistoryDeleter = (*Postgres)(nil)
+var _ database.Handler = (*Postgres)(nil)
+var _ database.Cleaner = (*Postgres)(nil)
+var _ database.StatementRunner = (*Postgres)(nil)
+
+//go:embed shistory.sql
+var sHistoryScript string
+
+type Config struct {
+	HistoryTable string
+}
+
+type Postgres struct {
+	db *sql.DB
+	tx *sql.Tx
+
+	historyTable string
+
+	historyReady bool
+	tUsername    string
+}
+
+func Open(url string) (database.Driver, error) {
+	purl, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if purl.Hostname() == "" {
+		purl.Host = "127.0.0.1" + purl.Host
+	}
+
+	if purl.Port() == "" {
+		purl.Host = purl.Host + ":5432"
+	}
+
+	cfg := Config{
+		HistoryTable: purl.Query().Get("x-history-table"),
+	}
+
+	// custom parameters are not understood by the server, strip them before
+	// handing the url to the postgres driver.
+	query := purl.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "x-") {
+			query.Del(key)
+		}
+	}
+	purl.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", purl.String())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetConnMaxLifetime(time.Minute * 3)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(10)
+
+	return WithInstance(db, cfg)
+}
+
+func WithInstance(inst *sql.DB, cfg Config) (database.Driver, error) {
+	if cfg.HistoryTable == "" {
+		cfg.HistoryTable = "migration_history"
+	}
+
+	return &Postgres{
+		db:           inst,
+		historyTable: cfg.HistoryTable,
+	}, nil
+}
+
+func (i *Postgres) Name() string {
+	return "postgres"
+}
+
+func (i *Postgres) Close() error {
+	return i.db.Close()
+}
+
+func (i *Postgres) Read() ([]*database.History, error) {
+	return i.ReadContext(context.Background())
+}
+
+func (i *Postgres) ReadContext(ctx context.Context) ([]*database.History, error) {
+	exists, err := i.historyTableExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// reading must not leave anything behind, the table is created by the
+	// first write.
+	if !exists {
+		return make([]*database.History, 0), nil
+	}
+	i.historyReady = true
+
+	table := pq.QuoteIdentifier(i.historyTable)
+	query := fmt.Sprintf(
+		`SELECT "rank", "mode", "version", "script_name", "description", "checksum", "applied_by", "applied_at", "execution_time", "success", "statements" FROM %s AS "h1" WHERE "h1"."rank" = (SELECT MAX("h2"."rank") FROM %s AS "h2" WHERE "h2"."version" = "h1"."version" AND "h2"."mode" = "h1"."mode" AND "h2"."checksum" = "h1"."checksum") ORDER BY "h1"."rank"`,
+		table,
+		table,
+	)
+	rows, err := i.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*database.History, 0)
+
+	for rows.Next() {
+		var row database.History
+
+		err := rows.Scan(
+			&row.Rank,
+			&row.Mode,
+			&row.Version,
+			&row.ScriptName,
+			&row.Description,
+			&row.Checksum,
+			&row.AppliedBy,
+			&row.AppliedAt,
+			&row.ExecutionTime,
+			&row.Success,
+			&row.Statements,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, &row)
+	}
+
+	return res, rows.Err()
+}
+
+func (i *Postgres) Write(history *database.History) error {
+	return i.WriteContext(context.Background(), history)
+}
+
+func (i *Postgres) WriteContext(ctx context.Context, history *database.History) error {
+	if !i.historyReady {
+		if _, err := i.historyTableExists(true); err != nil {
+			return err
+		}
+		i.historyReady = true
+	}
+
+	if history.AppliedBy == "" {
+		history.AppliedBy = i.username()
+	}
+
+	table := pq.QuoteIdentifier(i.historyTable)
+	args := []any{
+		history.Mode,
+		history.Version,
+		history.ScriptName,
+		history.Description,
+		history.Checksum,
+		history.AppliedBy,
+		history.AppliedAt,
+		history.ExecutionTime,
+		history.Success,
+		history.Statements,
+	}
+
+	if history.Rank > 0 {
+		query := fmt.Sprintf(
+			`INSERT INTO %s ("mode", "version", "script_name", "description", "checksum", "applied_by", "applied_at", "execution_time", "success", "statements", "rank") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) `+
+				`ON CONFLICT ("rank") DO UPDATE SET "mode" = EXCLUDED."mode", "version" = EXCLUDED."version", "script_name" = EXCLUDED."script_name", "description" = EXCLUDED."description", "checksum" = EXCLUDED."checksum", "applied_by" = EXCLUDED."applied_by", "applied_at" = EXCLUDED."applied_at", "execution_time" = EXCLUDED."execution_time", "success" = EXCLUDED."success", "statements" = EXCLUDED."statements"`,
+			table,
+		)
+		_, err := i.conn().ExecContext(ctx, query, append(args, int64(history.Rank))...)
+		return err
+	}
+
+	var rank int64
+	query := fmt.Sprintf(
+		`INSERT INTO %s ("mode", "version", "script_name", "description", "checksum", "applied_by", "applied_at", "execution_time", "success", "statements") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING "rank"`,
+		table,
+	)
+	if err := i.conn().QueryRowContext(ctx, query, args...).Scan(&rank); err != nil {
+		return err
+	}
+
+	history.Rank = uint64(rank)
+	return nil
+}
+
+func (i *Postgres) Delete(history *database.History) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE "rank" = $1`, pq.QuoteIdentifier(i.historyTable))
+	_, err := i.conn().Exec(query, int64(history.Rank))
+	return err
+}
+
+func (i *Postgres) Run(migration io.Reader) error {
+	return i.RunContext(context.Background(), migration)
+}
+
+func (i *Postgres) RunContext(ctx context.Context, migration io.Reader) error {
+	return i.RunStatements(ctx, migration, nil)
+}
+
+// RunStatements runs the statements of migration one by one.
+func (i *Postgres) RunStatements(ctx context.Context, migration io.Reader, progress func(event *database.StatementEvent)) error {
+	mg, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	statements, err := splitter.Split(string(mg), splitter.Postgres)
+	if err != nil {
+		return err
+	}
+
+	if i.tx != nil {
+		return splitter.Run(ctx, i.tx, statements, progress)
+	}
+
+	// statements share the session, e.g. SET search_path, so they run on the
+	// same connection.
+	conn, err := i.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return splitter.Run(ctx, conn, statements, progress)
+}
+
+// Purge drops every object owned by the current schema, history table
+// included.
+func (i *Postgres) Purge() []error {
+	objects, err := i.Objects(database.CleanOptions{})
+	if err != nil {
+		return []error{err}
+	}
+
+	// every object goes, dependencies do not need to be waited for.
+	return i.clean(objects, true)
+}
+
+// Objects lists views, tables, sequences, routines, domains then types,
+// sorted by name. Triggers and the sequences owned by a column go away with
+// their table, objects created by extensions are left out. Routines are named
+// after their signature, e.g. add(integer, integer).
+func (i *Postgres) Objects(opts database.CleanOptions) ([]database.Object, error) {
+	queries := []struct {
+		objectType database.ObjectType
+		query      string
+	}{
+		{database.ObjectView, `SELECT table_name, table_name FROM information_schema.views
+			WHERE table_schema = current_schema() ORDER BY table_name`},
+		{database.ObjectTable, `SELECT table_name, table_name FROM information_schema.tables
+			WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`},
+		{database.ObjectSequence, `SELECT c.relname, c.relname FROM pg_class AS c
+			INNER JOIN pg_namespace AS n ON n.oid = c.relnamespace
+			WHERE n.nspname = current_schema() AND c.relkind = 'S'
+			AND NOT EXISTS (SELECT 1 FROM pg_depend AS d WHERE d.objid = c.oid AND d.deptype IN ('a', 'i', 'e'))
+			ORDER BY c.relname`},
+		{database.ObjectFunction, i.routinesQuery("f")},
+		{database.ObjectProcedure, i.routinesQuery("p")},
+		{database.ObjectDomain, i.typesQuery("t.typtype = 'd'")},
+		// composite types backing a table or view go away with their relation.
+		{database.ObjectCustomType, i.typesQuery("t.typtype IN ('c', 'e', 'r') AND (t.typrelid = 0 OR c.relkind = 'c')")},
+	}
+
+	objects := make([]database.Object, 0)
+	for _, item := range queries {
+		rows, err := i.db.Query(item.query)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var name string
+			object := database.Object{Type: item.objectType}
+			if err := rows.Scan(&name, &object.Name); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+
+			// routines are also selected by their name alone.
+			if opts.Selects(object) && opts.Selects(database.Object{Type: object.Type, Name: name}) {
+				objects = append(objects, object)
+			}
+		}
+		_ = rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+func (i *Postgres) routinesQuery(kind string) string {
+	return fmt.Sprintf(`SELECT p.proname, p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')'
+		FROM pg_proc AS p
+		INNER JOIN pg_namespace AS n ON n.oid = p.pronamespace
+		WHERE n.nspname = current_schema() AND p.prokind = '%s'
+		AND NOT EXISTS (SELECT 1 FROM pg_depend AS d WHERE d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY 2`, kind)
+}
+
+func (i *Postgres) typesQuery(condition string) string {
+	return fmt.Sprintf(`SELECT t.typname, t.typname
+		FROM pg_type AS t
+		INNER JOIN pg_namespace AS n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class AS c ON c.oid = t.typrelid
+		WHERE n.nspname = current_schema() AND %s
+		AND NOT EXISTS (SELECT 1 FROM pg_depend AS d WHERE d.objid = t.oid AND d.deptype = 'e')
+		ORDER BY t.typname`, condition)
+}
+
+// Clean drops the given objects and nothing else. An object still needed by
+// another one is dropped once that one is gone, otherwise it is reported.
+func (i *Postgres) Clean(objects []database.Object) []error {
+	return i.clean(objects, false)
+}
+
+func (i *Postgres) clean(objects []database.Object, cascade bool) []error {
+	remaining := objects
+	for {
+		failed := make([]database.Object, 0)
+		errorItems := make([]error, 0)
+
+		for _, object := range remaining {
+			if err := i.drop(object, cascade); err != nil {
+				failed = append(failed, object)
+				errorItems = append(errorItems, &database.ObjectError{Object: object, Err: err})
+				continue
+			}
+
+			if object.Type == database.ObjectTable && object.Name == i.historyTable {
+				i.historyReady = false
+			}
+		}
+
+		// another round only helps when some object was dropped in this one.
+		if len(failed) == 0 || len(failed) == len(remaining) {
+			return errorItems
+		}
+		remaining = failed
+	}
+}
+
+func (i *Postgres) drop(object database.Object, cascade bool) error {
+	target := pq.QuoteIdentifier(object.Name)
+	if object.Type == database.ObjectFunction || object.Type == database.ObjectProcedure {
+		name, arguments, _ := strings.Cut(object.Name, "(")
+		target = pq.QuoteIdentifier(name) + "(" + arguments
+	}
+
+	query := fmt.Sprintf("DROP %s IF EXISTS %s", strings.ToUpper(string(object.Type)), target)
+	if cascade {
+		query += " CASCADE"
+	}
+
+	_, err := i.db.Exec(query)
+	return err
+}
+
+// executor is satisfied by both *sql.DB and *sql.Tx.
+type executor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn returns the running transaction, if any, or the database handle.
+func (i *Postgres) conn() executor {
+	if i.tx != nil {
+		return i.tx
+	}
+
+	return i.db
+}
+
+// Handle returns the running transaction, or the database handle when there is
+// none.
+func (i *Postgres) Handle() database.Executor {
+	return i.conn()
+}
+
+func (i *Postgres) Begin() error {
+	if i.tx != nil {
+		return errors.New("postgres: transaction already started")
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	i.tx = tx
+	return nil
+}
+
+func (i *Postgres) Commit() error {
+	if i.tx == nil {
+		return errors.New("postgres: no transaction started")
+	}
+
+	err := i.tx.Commit()
+	i.tx = nil
+	return err
+}
+
+func (i *Postgres) Rollback() error {
+	if i.tx == nil {
+		return errors.New("postgres: no transaction started")
+	}
+
+	// the history table may have been created inside the transaction.
+	err := i.tx.Rollback()
+	i.tx = nil
+	i.historyReady = false
+	return err
+}
+
+func (i *Postgres) TransactionalDDL() error {
+	return nil
+}
+
+func (i *Postgres) historyTableExists(create bool) (bool, error) {
+	script := ""
+	if create {
+		table := pq.QuoteIdentifier(i.historyTable)
+		script = fmt.Sprintf(sHistoryScript, table, table)
+	}
+
+	exists, err := i.tableExists(i.historyTable, script)
+	if err != nil || !exists || i.historyReady {
+		return exists, err
+	}
+
+	return true, i.upgradeHistoryTable()
+}
+
+// upgradeHistoryTable adds the columns missing from history tables created by
+// older versions.
+func (i *Postgres) upgradeHistoryTable() error {
+	exists := false
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = 'statements')"
+	if err := i.db.QueryRow(query, i.historyTable).Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err := i.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "statements" integer NOT NULL DEFAULT 0`, pq.QuoteIdentifier(i.historyTable)))
+	return err
+}
+
+func (i *Postgres) tableExists(table string, script string) (bool, error) {
+	exists := false
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)"
+	if err := i.db.QueryRow(query, table).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if !exists {
+		if script == "" {
+			return false, nil
+		}
+
+		if _, err := i.db.Exec(script); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (i *Postgres) username() string {
+	if i.tUsername != "" {
+		return i.tUsername
+	}
+
+	query := "SELECT CURRENT_USER"
+	if err := i.db.QueryRow(query).Scan(&i.tUsername); err != nil {
+		i.tUsername = "-"
+	}
+
+	return i.tUsername
+}
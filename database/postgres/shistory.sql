@@ -0,0 +1,15 @@
+CREATE TABLE %s (
+    "rank"              bigserial           NOT NULL,
+    "mode"              char(3)             NOT NULL,
+    "version"           varchar(255)        NOT NULL,
+    "script_name"       varchar(255)        NOT NULL,
+    "description"       varchar(255)        NOT NULL    DEFAULT '',
+    "checksum"          varchar(32)         NOT NULL    DEFAULT '',
+    "applied_by"        varchar(255)        NOT NULL,
+    "applied_at"        bigint              NOT NULL,
+    "execution_time"    integer             NOT NULL    DEFAULT 0,
+    "success"           boolean             NOT NULL    DEFAULT false,
+    "statements"        integer             NOT NULL    DEFAULT 0,
+    PRIMARY KEY("rank")
+);
+CREATE INDEX ON %s ("mode", "version")
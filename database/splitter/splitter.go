@@ -0,0 +1,476 @@
+// Package splitter splits migration scripts into statements, so drivers can run
+// them one by one and tell which statement failed.
+package splitter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Dialect int
+
+const (
+	// Generic understands single and double quotes, -- and /* */ comments.
+	Generic Dialect = iota
+
+	// MySQL adds backticks, backslash escapes, # comments and the DELIMITER
+	// command of the mysql client.
+	MySQL
+
+	// Postgres adds dollar quoted strings, E'' strings and nested comments.
+	Postgres
+
+	// SQLite adds backticks and square bracket identifiers.
+	SQLite
+)
+
+// Statement is a single statement of a script, without its delimiter.
+type Statement struct {
+	// Index is the position of the statement in the script, starting at 1.
+	Index int
+
+	// Line is the line the statement starts at, starting at 1.
+	Line int
+
+	Text string
+}
+
+// StatementError is returned by drivers when a statement of a script fails.
+type StatementError struct {
+	Statement *Statement
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	text := strings.Join(strings.Fields(e.Statement.Text), " ")
+	if len(text) > 200 {
+		text = text[:197] + "..."
+	}
+
+	return fmt.Sprintf("statement %d at line %d failed: %v [%s]", e.Statement.Index, e.Statement.Line, e.Err, text)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// Execer runs a single statement, *sql.DB, *sql.Conn and *sql.Tx satisfy it.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Run runs statements one by one with conn and stops at the first failing
+// one, which is reported by a StatementError. progress, when not nil, is
+// called after each statement.
+func Run(ctx context.Context, conn Execer, statements []*Statement, progress func(event *database.StatementEvent)) error {
+	for _, statement := range statements {
+		startTime := time.Now()
+		res, err := conn.ExecContext(ctx, statement.Text)
+
+		event := &database.StatementEvent{
+			Index:        statement.Index,
+			Line:         statement.Line,
+			Duration:     time.Since(startTime),
+			RowsAffected: -1,
+			Err:          err,
+		}
+
+		if err != nil {
+			event.Err = &StatementError{Statement: statement, Err: err}
+		} else if rows, rowsErr := res.RowsAffected(); rowsErr == nil {
+			event.RowsAffected = rows
+		}
+
+		if progress != nil {
+			progress(event)
+		}
+
+		if event.Err != nil {
+			return event.Err
+		}
+	}
+
+	return nil
+}
+
+// Split returns the statements of script. Delimiters inside quotes, comments
+// and BEGIN ... END bodies of routines, triggers and events do not split, nor
+// does the DELIMITER command of MySQL end up in a statement. Statements made
+// of comments only are dropped.
+func Split(script string, dialect Dialect) ([]*Statement, error) {
+	s := &scanner{
+		src:       []rune(script),
+		dialect:   dialect,
+		delimiter: ";",
+		line:      1,
+	}
+
+	return s.split()
+}
+
+type scanner struct {
+	src       []rune
+	pos       int
+	dialect   Dialect
+	delimiter string
+	line      int
+
+	statements []*Statement
+	start      int
+	startLine  int
+	hasCode    bool
+
+	// words are the first words of the current statement, until they tell
+	// whether it creates a routine.
+	words   []string
+	decided bool
+	routine bool
+	depth   int
+}
+
+func (s *scanner) split() ([]*Statement, error) {
+	s.reset(0)
+
+	for s.pos < len(s.src) {
+		r := s.src[s.pos]
+
+		if !s.hasCode && s.dialect == MySQL && s.isDelimiterCommand() {
+			if err := s.delimiterCommand(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// a custom delimiter is trusted to end routines by itself.
+		if (s.depth == 0 || s.delimiter != ";") && s.hasPrefix(s.delimiter) {
+			s.flush(s.pos)
+			s.pos += len([]rune(s.delimiter))
+			s.reset(s.pos)
+			continue
+		}
+
+		switch {
+		case r == '\n':
+			s.line++
+			s.pos++
+		case unicode.IsSpace(r):
+			s.pos++
+		case s.isLineComment():
+			s.skipUntil("\n", false)
+		case s.hasPrefix("/*"):
+			s.skipBlockComment()
+		case r == '\'' || r == '"' || (r == '`' && s.dialect != Postgres && s.dialect != Generic):
+			s.code()
+			s.skipQuoted(r, s.backslashEscapes(r))
+		case r == '[' && s.dialect == SQLite:
+			s.code()
+			s.skipUntil("]", true)
+		case r == '$' && s.dialect == Postgres && s.isDollarQuote():
+			s.code()
+			s.skipDollarQuoted()
+		case isWordRune(r):
+			s.code()
+			s.word()
+		default:
+			s.code()
+			s.pos++
+		}
+	}
+
+	s.flush(len(s.src))
+	return s.statements, nil
+}
+
+// reset starts a new statement at pos.
+func (s *scanner) reset(pos int) {
+	s.start = pos
+	s.hasCode = false
+	s.words = s.words[:0]
+	s.decided = false
+	s.routine = false
+	s.depth = 0
+}
+
+// flush appends the current statement, ending at end, unless it is empty.
+func (s *scanner) flush(end int) {
+	if !s.hasCode {
+		return
+	}
+
+	s.statements = append(s.statements, &Statement{
+		Index: len(s.statements) + 1,
+		Line:  s.startLine,
+		Text:  strings.TrimSpace(string(s.src[s.start:end])),
+	})
+}
+
+// code marks the current statement as having code, it starts at its first
+// code so that leading comments are left out.
+func (s *scanner) code() {
+	if !s.hasCode {
+		s.hasCode = true
+		s.start = s.pos
+		s.startLine = s.line
+	}
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	runes := []rune(prefix)
+	if s.pos+len(runes) > len(s.src) {
+		return false
+	}
+
+	for c, r := range runes {
+		if s.src[s.pos+c] != r {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *scanner) isLineComment() bool {
+	if s.dialect == MySQL && s.src[s.pos] == '#' {
+		return true
+	}
+
+	if !s.hasPrefix("--") {
+		return false
+	}
+
+	// mysql requires a whitespace after --, 1--1 is an expression.
+	if s.dialect == MySQL {
+		return s.pos+2 >= len(s.src) || unicode.IsSpace(s.src[s.pos+2])
+	}
+
+	return true
+}
+
+func (s *scanner) skipBlockComment() {
+	depth := 0
+	for s.pos < len(s.src) {
+		switch {
+		case s.hasPrefix("/*"):
+			// only postgres nests block comments.
+			if depth == 0 || s.dialect == Postgres {
+				depth++
+			}
+			s.pos += 2
+		case s.hasPrefix("*/"):
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				return
+			}
+		default:
+			if s.src[s.pos] == '\n' {
+				s.line++
+			}
+			s.pos++
+		}
+	}
+}
+
+// skipUntil moves past the next occurrence of end, or to the end of the script.
+// end itself is skipped when inclusive.
+func (s *scanner) skipUntil(end string, inclusive bool) {
+	for s.pos < len(s.src) {
+		if s.hasPrefix(end) {
+			if inclusive {
+				s.pos += len([]rune(end))
+			}
+			return
+		}
+
+		if s.src[s.pos] == '\n' {
+			s.line++
+		}
+		s.pos++
+	}
+}
+
+func (s *scanner) backslashEscapes(quote rune) bool {
+	if quote == '`' {
+		return false
+	}
+
+	if s.dialect == MySQL {
+		return true
+	}
+
+	// postgres escape strings, E'it\'s'.
+	if s.dialect == Postgres && quote == '\'' && s.pos > 0 && (s.src[s.pos-1] == 'E' || s.src[s.pos-1] == 'e') {
+		return s.pos == 1 || !isWordRune(s.src[s.pos-2])
+	}
+
+	return false
+}
+
+// skipQuoted moves past a quoted string or identifier, a doubled quote does
+// not end it.
+func (s *scanner) skipQuoted(quote rune, backslash bool) {
+	s.pos++
+	for s.pos < len(s.src) {
+		r := s.src[s.pos]
+		switch {
+		case r == '\\' && backslash:
+			s.pos++
+			if s.pos < len(s.src) && s.src[s.pos] == '\n' {
+				s.line++
+			}
+		case r == quote:
+			if s.pos+1 < len(s.src) && s.src[s.pos+1] == quote {
+				s.pos++
+			} else {
+				s.pos++
+				return
+			}
+		case r == '\n':
+			s.line++
+		}
+		s.pos++
+	}
+}
+
+// isDollarQuote returns true when the script is at a dollar quote opening
+// such as $$ or $body$, $1 is a parameter.
+func (s *scanner) isDollarQuote() bool {
+	if s.pos > 0 && isWordRune(s.src[s.pos-1]) {
+		return false
+	}
+
+	for c := s.pos + 1; c < len(s.src); c++ {
+		r := s.src[c]
+		if r == '$' {
+			return true
+		}
+
+		if !isWordRune(r) || (c == s.pos+1 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+
+	return false
+}
+
+func (s *scanner) skipDollarQuoted() {
+	end := s.pos + 1
+	for s.src[end] != '$' {
+		end++
+	}
+
+	tag := string(s.src[s.pos : end+1])
+	s.pos = end + 1
+	s.skipUntil(tag, true)
+}
+
+// word reads a keyword or an identifier and keeps track of BEGIN ... END
+// bodies, which only matter in statements creating routines, triggers or
+// events.
+func (s *scanner) word() {
+	start := s.pos
+	for s.pos < len(s.src) && isWordRune(s.src[s.pos]) {
+		s.pos++
+	}
+	word := strings.ToUpper(string(s.src[start:s.pos]))
+
+	if !s.decided {
+		s.words = append(s.words, word)
+		switch {
+		case s.words[0] != "CREATE" || len(s.words) > 8:
+			s.decided = true
+		case word == "PROCEDURE" || word == "FUNCTION" || word == "TRIGGER" || word == "EVENT":
+			s.decided, s.routine = true, true
+		case word == "TABLE" || word == "VIEW" || word == "INDEX" || word == "DATABASE" || word == "SCHEMA":
+			s.decided = true
+		}
+	}
+
+	if !s.routine {
+		return
+	}
+
+	switch word {
+	case "BEGIN", "CASE":
+		s.depth++
+	case "END":
+		// END IF, END LOOP, ... close blocks that are not counted, END CASE
+		// closes a CASE statement.
+		switch s.nextWord() {
+		case "IF", "LOOP", "WHILE", "REPEAT", "FOR":
+			return
+		}
+
+		if s.depth > 0 {
+			s.depth--
+		}
+
+		if s.nextWord() == "CASE" {
+			s.skipNextWord()
+		}
+	}
+}
+
+// nextWord returns the word following the current position, skipping
+// whitespace only.
+func (s *scanner) nextWord() string {
+	c := s.pos
+	for c < len(s.src) && unicode.IsSpace(s.src[c]) {
+		c++
+	}
+
+	start := c
+	for c < len(s.src) && isWordRune(s.src[c]) {
+		c++
+	}
+
+	return strings.ToUpper(string(s.src[start:c]))
+}
+
+func (s *scanner) skipNextWord() {
+	for s.pos < len(s.src) && unicode.IsSpace(s.src[s.pos]) {
+		if s.src[s.pos] == '\n' {
+			s.line++
+		}
+		s.pos++
+	}
+
+	for s.pos < len(s.src) && isWordRune(s.src[s.pos]) {
+		s.pos++
+	}
+}
+
+func (s *scanner) isDelimiterCommand() bool {
+	const command = "DELIMITER"
+	if s.pos+len(command) >= len(s.src) {
+		return false
+	}
+
+	word := string(s.src[s.pos : s.pos+len(command)])
+	return strings.EqualFold(word, command) && unicode.IsSpace(s.src[s.pos+len(command)])
+}
+
+// delimiterCommand reads a DELIMITER command, which takes the rest of the line.
+func (s *scanner) delimiterCommand() error {
+	line := s.line
+	start := s.pos
+	s.skipUntil("\n", false)
+
+	fields := strings.Fields(string(s.src[start:s.pos]))
+	if len(fields) < 2 {
+		return fmt.Errorf("splitter: missing delimiter at line %d", line)
+	}
+
+	s.delimiter = fields[1]
+	s.reset(s.pos)
+	return nil
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,127 @@
+package splitter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func texts(t *testing.T, script string, dialect Dialect) []string {
+	t.Helper()
+
+	statements, err := Split(script, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([]string, 0, len(statements))
+	for _, statement := range statements {
+		result = append(result, statement.Text)
+	}
+
+	return result
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		script   string
+		expected []string
+	}{
+		{
+			name:     "quotes and comments",
+			dialect:  MySQL,
+			script:   "-- first; comment\nINSERT INTO t VALUES ('a;b', \"c\\\";\", `d;`); # trailing; comment\n/* block; */ SELECT 1--1;\n-- only a comment;",
+			expected: []string{"INSERT INTO t VALUES ('a;b', \"c\\\";\", `d;`)", "SELECT 1--1"},
+		},
+		{
+			name:    "delimiter",
+			dialect: MySQL,
+			script:  "DELIMITER $$\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND$$\ndelimiter ;\nCALL p();",
+			expected: []string{
+				"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND",
+				"CALL p()",
+			},
+		},
+		{
+			name:    "begin end bodies",
+			dialect: MySQL,
+			script: "CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW\nBEGIN\n  IF NEW.a > 0 THEN\n    SET NEW.b = CASE WHEN NEW.a > 1 THEN 2 ELSE 1 END;\n  END IF;\nEND;\n" +
+				"START TRANSACTION; BEGIN; CREATE TABLE events (begin int, end int);",
+			expected: []string{
+				"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW\nBEGIN\n  IF NEW.a > 0 THEN\n    SET NEW.b = CASE WHEN NEW.a > 1 THEN 2 ELSE 1 END;\n  END IF;\nEND",
+				"START TRANSACTION",
+				"BEGIN",
+				"CREATE TABLE events (begin int, end int)",
+			},
+		},
+		{
+			name:    "dollar quotes",
+			dialect: Postgres,
+			script:  "CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;\nSELECT $1, E'it\\'s;', 'it''s;' /* a /* nested; */ comment */;",
+			expected: []string{
+				"CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql",
+				"SELECT $1, E'it\\'s;', 'it''s;' /* a /* nested; */ comment */",
+			},
+		},
+		{
+			name:    "sqlite trigger",
+			dialect: SQLite,
+			script:  "CREATE TRIGGER tr AFTER INSERT ON [my;table] BEGIN UPDATE t SET a = 1; DELETE FROM u; END;\nSELECT 1",
+			expected: []string{
+				"CREATE TRIGGER tr AFTER INSERT ON [my;table] BEGIN UPDATE t SET a = 1; DELETE FROM u; END",
+				"SELECT 1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := texts(t, test.script, test.dialect)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("unexpected statements\n%q\nexpected\n%q", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestSplitPosition(t *testing.T) {
+	statements, err := Split("-- header\n\nSELECT 1;\nSELECT\n2; SELECT 3", MySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make([]int, 0)
+	for c, statement := range statements {
+		if statement.Index != c+1 {
+			t.Fatalf("statement %v has index %v", c+1, statement.Index)
+		}
+		lines = append(lines, statement.Line)
+	}
+
+	if expected := []int{3, 4, 5}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("unexpected lines %v, expected %v", lines, expected)
+	}
+
+	if _, err = Split("DELIMITER \nSELECT 1;", MySQL); err == nil {
+		t.Fatal("expected missing delimiter to fail")
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	cause := errors.New("syntax error")
+	err := &StatementError{
+		Statement: &Statement{Index: 2, Line: 7, Text: "SELECT\n  broken"},
+		Err:       cause,
+	}
+
+	if !errors.Is(err, cause) {
+		t.Fatal("expected the error to wrap its cause")
+	}
+
+	if !strings.Contains(err.Error(), "statement 2 at line 7 failed: syntax error [SELECT broken]") {
+		t.Fatalf("unexpected message %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE "%s" (
+    "rank"              integer             NOT NULL    PRIMARY KEY AUTOINCREMENT,
+    "mode"              char(3)             NOT NULL,
+    "version"           varchar(255)        NOT NULL,
+    "script_name"       varchar(255)        NOT NULL,
+    "description"       varchar(255)        NOT NULL    DEFAULT '',
+    "checksum"          char(32)            NOT NULL    DEFAULT '',
+    "applied_by"        varchar(255)        NOT NULL,
+    "applied_at"        bigint              NOT NULL,
+    "execution_time"    integer             NOT NULL    DEFAULT 0,
+    "success"           boolean             NOT NULL    DEFAULT 0,
+    "statements"        integer             NOT NULL    DEFAULT 0
+);
+CREATE INDEX "%s_mode_version" ON "%s" ("mode", "version")
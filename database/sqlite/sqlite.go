@@ -0,0 +1,458 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/database/splitter"
+	_ "github.com/mattn/go-sqlite3"
+	"io"
+	nurl "net/url"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+var _ database.Driver = (*SQLite)(nil)
+var _ database.Transactor = (*SQLite)(nil)
+var _ database.ContextDriver = (*SQLite)(nil)
+var _ database.Cleaner = (*SQLite)(nil)
+var _ database.HistoryDeleter = (*SQLite)(nil)
+var _ database.Handler = (*SQLite)(nil)
+var _ database.StatementRunner = (*SQLite)(nil)
+
+//go:embed shistory.sql
+var sHistoryScript string
+
+type Config struct {
+	HistoryTable string
+}
+
+type SQLite struct {
+	db *sql.DB
+	tx *sql.Tx
+
+	historyTable string
+
+	historyReady bool
+	tUsername    string
+}
+
+// Open accepts urls such as sqlite://./local.db, sqlite:///var/lib/app.db or
+// sqlite://:memory:. Query parameters other than x-* are passed to the sqlite
+// driver as connection options.
+func Open(url string) (database.Driver, error) {
+	purl, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Config{
+		HistoryTable: purl.Query().Get("x-history-table"),
+	}
+
+	query := purl.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "x-") {
+			query.Del(key)
+		}
+	}
+
+	dsn := filepath.Join(purl.Host, purl.Path)
+	if dsn == "." || dsn == "" {
+		dsn = ":memory:"
+	}
+
+	if len(query) > 0 {
+		dsn = "file:" + dsn + "?" + query.Encode()
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer, and every connection to :memory: opens a
+	// brand-new database. keep everything on one connection.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	return WithInstance(db, cfg)
+}
+
+func WithInstance(inst *sql.DB, cfg Config) (database.Driver, error) {
+	if cfg.HistoryTable == "" {
+		cfg.HistoryTable = "migration_history"
+	}
+
+	return &SQLite{
+		db:           inst,
+		historyTable: cfg.HistoryTable,
+	}, nil
+}
+
+func (i *SQLite) Name() string {
+	return "sqlite"
+}
+
+func (i *SQLite) Close() error {
+	return i.db.Close()
+}
+
+func (i *SQLite) Read() ([]*database.History, error) {
+	return i.ReadContext(context.Background())
+}
+
+func (i *SQLite) ReadContext(ctx context.Context) ([]*database.History, error) {
+	exists, err := i.historyTableExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// reading must not leave anything behind, the table is created by the
+	// first write.
+	if !exists {
+		return make([]*database.History, 0), nil
+	}
+	i.historyReady = true
+
+	query := fmt.Sprintf(
+		`SELECT "rank", "mode", "version", "script_name", "description", "checksum", "applied_by", "applied_at", "execution_time", "success", "statements" FROM "%s" AS "h1" WHERE "h1"."rank" = (SELECT MAX("h2"."rank") FROM "%s" AS "h2" WHERE "h2"."version" = "h1"."version" AND "h2"."mode" = "h1"."mode" AND "h2"."checksum" = "h1"."checksum") ORDER BY "h1"."rank"`,
+		i.historyTable,
+		i.historyTable,
+	)
+	rows, err := i.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*database.History, 0)
+
+	for rows.Next() {
+		var row database.History
+
+		err := rows.Scan(
+			&row.Rank,
+			&row.Mode,
+			&row.Version,
+			&row.ScriptName,
+			&row.Description,
+			&row.Checksum,
+			&row.AppliedBy,
+			&row.AppliedAt,
+			&row.ExecutionTime,
+			&row.Success,
+			&row.Statements,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, &row)
+	}
+
+	return res, rows.Err()
+}
+
+func (i *SQLite) Write(history *database.History) error {
+	return i.WriteContext(context.Background(), history)
+}
+
+func (i *SQLite) WriteContext(ctx context.Context, history *database.History) error {
+	if !i.historyReady {
+		if _, err := i.historyTableExists(true); err != nil {
+			return err
+		}
+		i.historyReady = true
+	}
+
+	if history.AppliedBy == "" {
+		history.AppliedBy = i.username()
+	}
+
+	var insertedRank any = nil
+	query := fmt.Sprintf(`INSERT INTO "%s" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, i.historyTable)
+	if history.Rank > 0 {
+		query = fmt.Sprintf(`REPLACE INTO "%s" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, i.historyTable)
+		insertedRank = int64(history.Rank)
+	}
+
+	res, err := i.conn().ExecContext(ctx,
+		query,
+		insertedRank,
+		history.Mode,
+		history.Version,
+		history.ScriptName,
+		history.Description,
+		history.Checksum,
+		history.AppliedBy,
+		history.AppliedAt,
+		history.ExecutionTime,
+		history.Success,
+		history.Statements,
+	)
+	if err != nil {
+		return err
+	}
+
+	if insertedRank == nil {
+		insertedRank, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
+
+	history.Rank = uint64(insertedRank.(int64))
+	return nil
+}
+
+func (i *SQLite) Delete(history *database.History) error {
+	query := fmt.Sprintf(`DELETE FROM "%s" WHERE "rank" = ?`, i.historyTable)
+	_, err := i.conn().Exec(query, int64(history.Rank))
+	return err
+}
+
+// Run executes the whole script inside a single transaction, sqlite supports
+// transactional DDL so a failing statement leaves the schema untouched. When a
+// transaction is already started with Begin, the script joins it.
+func (i *SQLite) Run(migration io.Reader) error {
+	return i.RunContext(context.Background(), migration)
+}
+
+func (i *SQLite) RunContext(ctx context.Context, migration io.Reader) error {
+	return i.RunStatements(ctx, migration, nil)
+}
+
+// RunStatements runs the statements of migration one by one, inside a
+// transaction when none is running yet.
+func (i *SQLite) RunStatements(ctx context.Context, migration io.Reader, progress func(event *database.StatementEvent)) error {
+	mg, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	statements, err := splitter.Split(string(mg), splitter.SQLite)
+	if err != nil {
+		return err
+	}
+
+	if i.tx != nil {
+		return splitter.Run(ctx, i.tx, statements, progress)
+	}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = splitter.Run(ctx, tx, statements, progress); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (i *SQLite) Purge() []error {
+	objects, err := i.Objects(database.CleanOptions{})
+	if err != nil {
+		return []error{err}
+	}
+
+	return i.Clean(objects)
+}
+
+// Objects lists triggers, views then tables, sorted by name. Procedures,
+// functions and events do not exist in sqlite, indexes go away with their
+// table.
+func (i *SQLite) Objects(opts database.CleanOptions) ([]database.Object, error) {
+	objects := make([]database.Object, 0)
+
+	for _, objectType := range []database.ObjectType{database.ObjectTrigger, database.ObjectView, database.ObjectTable} {
+		query := "SELECT name FROM sqlite_master WHERE type = ? AND name NOT LIKE 'sqlite_%' ORDER BY name"
+		rows, err := i.conn().Query(query, string(objectType))
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			object := database.Object{Type: objectType}
+			if err := rows.Scan(&object.Name); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+
+			if opts.Selects(object) {
+				objects = append(objects, object)
+			}
+		}
+		_ = rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+func (i *SQLite) Clean(objects []database.Object) []error {
+	errorItems := make([]error, 0)
+
+	if _, err := i.conn().Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return append(errorItems, err)
+	}
+
+	for _, object := range objects {
+		query := fmt.Sprintf(`DROP %s IF EXISTS "%s"`, strings.ToUpper(string(object.Type)), strings.ReplaceAll(object.Name, `"`, `""`))
+		if _, err := i.conn().Exec(query); err != nil {
+			errorItems = append(errorItems, &database.ObjectError{Object: object, Err: err})
+			continue
+		}
+
+		if object.Type == database.ObjectTable && object.Name == i.historyTable {
+			i.historyReady = false
+		}
+	}
+
+	if _, err := i.conn().Exec("PRAGMA foreign_keys = ON"); err != nil {
+		errorItems = append(errorItems, err)
+	}
+
+	return errorItems
+}
+
+// executor is satisfied by both *sql.DB and *sql.Tx.
+type executor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn returns the running transaction, if any, or the database handle.
+func (i *SQLite) conn() executor {
+	if i.tx != nil {
+		return i.tx
+	}
+
+	return i.db
+}
+
+// Handle returns the running transaction, or the database handle when there is
+// none.
+func (i *SQLite) Handle() database.Executor {
+	return i.conn()
+}
+
+func (i *SQLite) Begin() error {
+	if i.tx != nil {
+		return errors.New("sqlite: transaction already started")
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	i.tx = tx
+	return nil
+}
+
+func (i *SQLite) Commit() error {
+	if i.tx == nil {
+		return errors.New("sqlite: no transaction started")
+	}
+
+	err := i.tx.Commit()
+	i.tx = nil
+	return err
+}
+
+func (i *SQLite) Rollback() error {
+	if i.tx == nil {
+		return errors.New("sqlite: no transaction started")
+	}
+
+	// the history table may have been created inside the transaction.
+	err := i.tx.Rollback()
+	i.tx = nil
+	i.historyReady = false
+	return err
+}
+
+func (i *SQLite) TransactionalDDL() error {
+	return nil
+}
+
+func (i *SQLite) historyTableExists(create bool) (bool, error) {
+	script := ""
+	if create {
+		script = fmt.Sprintf(sHistoryScript, i.historyTable, i.historyTable, i.historyTable)
+	}
+
+	exists, err := i.tableExists(i.historyTable, script)
+	if err != nil || !exists || i.historyReady {
+		return exists, err
+	}
+
+	return true, i.upgradeHistoryTable()
+}
+
+// upgradeHistoryTable adds the columns missing from history tables created by
+// older versions.
+func (i *SQLite) upgradeHistoryTable() error {
+	exists := false
+	query := `SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE "name" = 'statements')`
+	if err := i.conn().QueryRow(query, i.historyTable).Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err := i.conn().Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "statements" integer NOT NULL DEFAULT 0`, i.historyTable))
+	return err
+}
+
+func (i *SQLite) tableExists(table string, script string) (bool, error) {
+	exists := false
+	query := "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)"
+	if err := i.conn().QueryRow(query, table).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if !exists {
+		if script == "" {
+			return false, nil
+		}
+
+		if _, err := i.conn().Exec(script); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// username returns the operating system user since sqlite has no notion of
+// database users.
+func (i *SQLite) username() string {
+	if i.tUsername != "" {
+		return i.tUsername
+	}
+
+	i.tUsername = "-"
+	if current, err := user.Current(); err == nil {
+		i.tUsername = current.Username
+	}
+
+	return i.tUsername
+}
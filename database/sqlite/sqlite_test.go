@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/database/splitter"
+	"strings"
+	"testing"
+)
+
+func openTest(t *testing.T) *SQLite {
+	drv, err := Open("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = drv.Close()
+	})
+
+	return drv.(*SQLite)
+}
+
+func TestRunRollsBackOnError(t *testing.T) {
+	db := openTest(t)
+
+	err := db.Run(strings.NewReader("CREATE TABLE users (id integer); CREATE TABLE;"))
+	if err == nil {
+		t.Fatal("expected script to fail")
+	}
+
+	exists, err := db.tableExists("users", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists {
+		t.Fatal("expected users table creation to be rolled back")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := openTest(t)
+
+	script := `
+		CREATE TABLE users (id integer PRIMARY KEY, name text UNIQUE);
+		CREATE TABLE posts (id integer PRIMARY KEY, user_id integer REFERENCES users (id));
+		CREATE INDEX posts_user ON posts (user_id);
+		CREATE VIEW named_users AS SELECT name FROM users;
+		CREATE TRIGGER users_delete AFTER DELETE ON users BEGIN DELETE FROM posts WHERE user_id = OLD.id; END;
+	`
+	if err := db.Run(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := db.Purge(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Fatalf("expected empty schema, %v objects left", count)
+	}
+}
+
+func TestClean(t *testing.T) {
+	db := openTest(t)
+
+	script := `
+		CREATE TABLE users (id integer PRIMARY KEY, name text);
+		CREATE TABLE audit_users (id integer PRIMARY KEY);
+		CREATE VIEW named_users AS SELECT name FROM users;
+	`
+	if err := db.Run(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := db.Objects(database.CleanOptions{
+		Types:   []database.ObjectType{database.ObjectTable},
+		Exclude: []string{"audit_*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 1 || objects[0].Name != "users" {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+
+	if errs := db.Clean(objects); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var names []string
+	rows, err := db.db.Query("SELECT name FROM sqlite_master ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if strings.Join(names, ",") != "audit_users,named_users" {
+		t.Fatalf("unexpected objects left %v", names)
+	}
+}
+
+func TestRunStatements(t *testing.T) {
+	db := openTest(t)
+
+	events := make([]*database.StatementEvent, 0)
+	err := db.RunStatements(context.Background(), strings.NewReader("CREATE TABLE users (id integer);\nINSERT INTO users VALUES (1), (2);\nCREATE TABLE;"), func(event *database.StatementEvent) {
+		events = append(events, event)
+	})
+	if err == nil {
+		t.Fatal("expected script to fail")
+	}
+
+	if len(events) != 3 || events[1].RowsAffected != 2 || events[2].Err == nil || events[2].Line != 3 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	var statementErr *splitter.StatementError
+	if !errors.As(err, &statementErr) || statementErr.Statement.Index != 3 {
+		t.Fatalf("expected the third statement to be reported, got %v", err)
+	}
+}
+
+func TestUpgradeHistoryTable(t *testing.T) {
+	db := openTest(t)
+
+	// history table as created before the statements column.
+	script := `CREATE TABLE "migration_history" (
+		"rank" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "mode" char(3) NOT NULL, "version" varchar(255) NOT NULL,
+		"script_name" varchar(255) NOT NULL, "description" varchar(255) NOT NULL DEFAULT '', "checksum" char(32) NOT NULL DEFAULT '',
+		"applied_by" varchar(255) NOT NULL, "applied_at" bigint NOT NULL, "execution_time" integer NOT NULL DEFAULT 0,
+		"success" boolean NOT NULL DEFAULT 0
+	);
+	INSERT INTO "migration_history" VALUES (1, 'ADV', '00001', '00001_init.sql', 'init', '', 'me', 1, 0, 1);`
+	if _, err := db.db.Exec(script); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Write(&database.History{Mode: "ADV", Version: "00002", ScriptName: "00002_next.sql", Statements: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	histories, err := db.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(histories) != 2 || histories[0].Statements != 0 || histories[1].Statements != 4 {
+		t.Fatalf("unexpected histories %+v", histories)
+	}
+}
@@ -0,0 +1,245 @@
+package concept
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// directivePrefix starts the directives read from the leading comments of a
+// script, e.g.
+//
+//	-- concept:no-transaction
+//	-- concept:timeout=5m
+//	-- concept:env=staging,production
+//	-- concept:requires=00012
+//	-- concept:irreversible
+const directivePrefix = "concept:"
+
+// Directives change how a single script is run, they are read from the
+// comments at the top of the script.
+type Directives struct {
+	// NoTransaction runs the script outside of any transaction, e.g. for
+	// statements that cannot run inside one.
+	NoTransaction bool
+
+	// Timeout interrupts the script once exceeded, zero means no timeout.
+	Timeout time.Duration
+
+	// Environments restricts the migration to the listed environments, every
+	// environment when empty.
+	Environments []string
+
+	// Requires lists the versions that must be applied before the migration.
+	Requires []string
+
+	// Irreversible refuses to roll back the migration, even when it has a
+	// reverse script.
+	Irreversible bool
+}
+
+// Allows returns true when the migration may run in the given environment.
+func (i Directives) Allows(environment string) bool {
+	if len(i.Environments) == 0 {
+		return true
+	}
+
+	for _, candidate := range i.Environments {
+		if candidate == environment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseDirectives reads the directives of the script. Only the leading
+// comments are read, the first statement ends them.
+func (i *Script) parseDirectives() error {
+	content, err := i.Content()
+	if err != nil {
+		return err
+	}
+
+	i.Directives = Directives{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(comment, directivePrefix) {
+			continue
+		}
+
+		if err = i.Directives.set(strings.TrimPrefix(comment, directivePrefix)); err != nil {
+			return fmt.Errorf("concept: invalid directive %q in %v: %w", comment, i.Identifier, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (i *Directives) set(directive string) error {
+	name, value, hasValue := strings.Cut(directive, "=")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+	switch name {
+	case "no-transaction", "irreversible":
+		if hasValue {
+			return fmt.Errorf("%v does not take a value", name)
+		}
+
+		if name == "no-transaction" {
+			i.NoTransaction = true
+		} else {
+			i.Irreversible = true
+		}
+	case "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		if timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		i.Timeout = timeout
+	case "env", "requires":
+		values := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+
+		if len(values) == 0 {
+			return fmt.Errorf("%v needs at least one value", name)
+		}
+
+		if name == "env" {
+			i.Environments = append(i.Environments, values...)
+		} else {
+			i.Requires = append(i.Requires, values...)
+		}
+	default:
+		return errors.New("unknown directive")
+	}
+
+	return nil
+}
+
+// SetEnvironment sets the environment the migrations run in. Migrations
+// restricted to other environments by their env directive are excluded, they
+// are neither applied nor reported as pending.
+func (i *Concept) SetEnvironment(environment string) {
+	i.environment = environment
+}
+
+// resolveEnvironment excludes the migrations waiting to be applied whose env
+// directive does not allow the current environment.
+func (i *Concept) resolveEnvironment() {
+	exclude := func(mg *Migration) {
+		if mg.AdvanceScript == nil || mg.AdvanceScript.Directives.Allows(i.environment) {
+			return
+		}
+
+		if mg.State&(pendingState|outdatedState) > 0 {
+			mg.State |= excludedState
+		}
+	}
+
+	for _, version := range i.versions {
+		exclude(i.migrations[version])
+	}
+
+	for _, name := range i.repeatableNames {
+		exclude(i.repeatables[name])
+	}
+}
+
+// checkRequires makes sure the requires directive of every target is met,
+// either by an applied migration or by a previous target.
+func (i *Concept) checkRequires(targets []*Migration) error {
+	applied := make(map[string]bool, len(i.versions))
+	for _, version := range i.versions {
+		mg := i.migrations[version]
+		applied[version] = (mg.State&successState > 0 && mg.State&undoneState == 0) || mg.State&baselineState > 0
+	}
+
+	for _, mg := range targets {
+		for _, required := range mg.AdvanceScript.Directives.Requires {
+			index, err := i.versionIndex(required)
+			if err != nil {
+				return fmt.Errorf("concept: %v requires unknown version %v", mg.AdvanceScript.Identifier, required)
+			}
+
+			if !applied[i.versions[index]] {
+				return fmt.Errorf("concept: %v requires version %v, which is not applied", mg.AdvanceScript.Identifier, i.versions[index])
+			}
+		}
+
+		if !mg.Repeatable {
+			applied[mg.Version] = true
+		}
+	}
+
+	return nil
+}
+
+// checkRequired refuses to roll back a migration still required by an applied
+// migration which is not rolled back along with it.
+func (i *Concept) checkRequired(targets []*Migration) error {
+	reverted := make(map[string]bool, len(targets))
+	for _, mg := range targets {
+		reverted[mg.Version] = true
+	}
+
+	for _, version := range i.versions {
+		mg := i.migrations[version]
+		applied := mg.State&successState > 0 && mg.State&undoneState == 0
+		if !applied || reverted[version] || mg.AdvanceScript == nil {
+			continue
+		}
+
+		for _, required := range mg.AdvanceScript.Directives.Requires {
+			index, err := i.versionIndex(required)
+			if err == nil && reverted[i.versions[index]] {
+				return fmt.Errorf("concept: cannot roll back %v, %v requires it", i.versions[index], mg.AdvanceScript.Identifier)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNoTransaction refuses to wrap a no-transaction script in the single
+// transaction of a batch.
+func (i *Concept) checkNoTransaction(targets []*Migration, direction Direction) error {
+	if !i.batchTransaction {
+		return nil
+	}
+
+	for _, mg := range targets {
+		script := mg.AdvanceScript
+		if direction == ReverseDirection {
+			script = mg.ReverseScript
+		}
+
+		if script.Directives.NoTransaction {
+			return fmt.Errorf("concept: %v cannot run inside a single transaction, it has the no-transaction directive", script.Identifier)
+		}
+	}
+
+	return nil
+}
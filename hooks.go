@@ -1,5 +1,7 @@
 package concept
 
+import "github.com/dityaaa/concept/database"
+
 type Hooks struct {
 	PreMigrate  func(m *Migration)
 	PostMigrate func(m *Migration)
@@ -8,4 +10,8 @@ type Hooks struct {
 	PreRollback  func(m *Migration)
 	PostRollback func(m *Migration)
 	RollbackErr  func(m *Migration, err error)
+
+	// Statement is called after every statement of a script, successful or
+	// not, when the database driver runs scripts statement by statement.
+	Statement func(m *Migration, event *database.StatementEvent)
 }
@@ -0,0 +1,37 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var baselineVersion string
+var baselineDescription string
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Adopt an existing database by marking migrations up to a version as applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptBaseline()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(baselineCmd)
+	baselineCmd.Flags().StringVar(&baselineVersion, "version", "", "Consider every migration at or below this version as applied")
+	baselineCmd.Flags().StringVar(&baselineDescription, "description", "Baseline", "Description recorded with the baseline")
+	_ = baselineCmd.MarkFlagRequired("version")
+}
+
+func conceptBaseline() {
+	fmt.Println("Preparing...")
+	con := newConcept(true, nil)
+
+	cobra.CheckErr(con.Baseline(baselineVersion, baselineDescription))
+	fmt.Println(color.GreenString("✔"), "Database baselined at version", baselineVersion)
+}
@@ -5,12 +5,34 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"os"
+	"strings"
 )
 
+var cleanTypes = map[database.ObjectType]*bool{
+	database.ObjectTable:      new(bool),
+	database.ObjectView:       new(bool),
+	database.ObjectProcedure:  new(bool),
+	database.ObjectFunction:   new(bool),
+	database.ObjectTrigger:    new(bool),
+	database.ObjectEvent:      new(bool),
+	database.ObjectSequence:   new(bool),
+	database.ObjectDomain:     new(bool),
+	database.ObjectCustomType: new(bool),
+}
+var cleanExclude []string
+var cleanYes bool
+
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
-	Short: "Show the status of each migration",
+	Short: "Drop database objects (tables, views, stored procedures, etc)",
+	Long: `Drop database objects (tables, views, stored procedures, etc).
+Without any object type flag, every object is dropped, migration history included.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		conceptClean()
 	},
@@ -18,8 +40,68 @@ var cleanCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(cleanCmd)
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectTable], "tables", false, "Drop tables")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectView], "views", false, "Drop views")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectProcedure], "procedures", false, "Drop stored procedures")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectFunction], "functions", false, "Drop stored functions")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectTrigger], "triggers", false, "Drop triggers")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectEvent], "events", false, "Drop events")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectSequence], "sequences", false, "Drop sequences not owned by a column (postgres)")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectDomain], "domains", false, "Drop domains (postgres)")
+	cleanCmd.Flags().BoolVar(cleanTypes[database.ObjectCustomType], "types", false, "Drop user-defined types (postgres)")
+	cleanCmd.Flags().StringSliceVar(&cleanExclude, "exclude", nil, "Comma separated object names, or patterns such as audit_*, to keep")
+	cleanCmd.Flags().BoolVarP(&cleanYes, "yes", "y", false, "Drop without asking for confirmation")
 }
 
 func conceptClean() {
-	panic("implement me")
+	con := newConcept(false, nil)
+
+	opts := database.CleanOptions{Exclude: cleanExclude}
+	for objectType, selected := range cleanTypes {
+		if *selected {
+			opts.Types = append(opts.Types, objectType)
+		}
+	}
+
+	objects, err := con.Objects(opts)
+	cobra.CheckErr(err)
+
+	if len(objects) == 0 {
+		fmt.Println("Nothing to clean")
+		return
+	}
+
+	fmt.Printf("%d object(s) will be dropped:\n", len(objects))
+	for _, object := range objects {
+		fmt.Printf(" - %-9s %s\n", object.Type, object.Name)
+	}
+
+	if !cleanYes && !confirm("Drop these objects?") {
+		fmt.Println("Clean cancelled")
+		return
+	}
+
+	errs := con.Clean(objects)
+	for _, err := range errs {
+		fmt.Println(color.RedString("✘"), err)
+	}
+
+	if len(errs) > 0 {
+		cobra.CheckErr(fmt.Errorf("clean completed with %v errors", len(errs)))
+	}
+
+	fmt.Println(color.GreenString("✔"), len(objects), "object(s) dropped")
+}
+
+// confirm asks a yes/no question on the terminal, anything but yes is a no.
+func confirm(question string) bool {
+	fmt.Print(question, " [y/N] ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
@@ -0,0 +1,169 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cliArgsEnv = "CONCEPT_TEST_CLI_ARGS"
+
+// TestCLIProcess runs the command line when started by runCLI, failing
+// commands exit the process.
+func TestCLIProcess(t *testing.T) {
+	args := os.Getenv(cliArgsEnv)
+	if args == "" {
+		t.Skip("only run by runCLI")
+	}
+
+	rootCmd.SetArgs(strings.Split(args, "\n"))
+	Execute()
+	os.Exit(0)
+}
+
+func runCLI(t *testing.T, args ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCLIProcess$")
+	cmd.Env = append(os.Environ(), cliArgsEnv+"="+strings.Join(args, "\n"))
+
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeConfig writes a config reading migrations from dir and pointing to
+// CONCEPT_MYSQL_URL, or to a closed port when it is not set.
+func writeConfig(t *testing.T, dir string) (string, bool) {
+	host, port, username, password, database := "127.0.0.1", "1", "concept", "concept", "concept_test"
+
+	url := os.Getenv("CONCEPT_MYSQL_URL")
+	if url != "" {
+		purl, err := nurl.Parse(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		host, port = purl.Hostname(), purl.Port()
+		username = purl.User.Username()
+		password, _ = purl.User.Password()
+		database = strings.TrimPrefix(purl.Path, "/")
+	}
+
+	config := fmt.Sprintf(`migration-path: %s
+history-table: cli_history
+locking-table: cli_locking
+driver:
+  mysql:
+    host: %s
+    port: %s
+    username: %s
+    password: %s
+    database: %s
+`, dir, host, port, username, password, database)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, url != ""
+}
+
+func TestCommands(t *testing.T) {
+	config, connected := writeConfig(t, t.TempDir())
+
+	commands := [][]string{
+		{"status"},
+	}
+
+	for _, args := range commands {
+		out, err := runCLI(t, append(args, "--config", config)...)
+		if strings.Contains(out, "panic") {
+			t.Fatalf("concept %v panicked:\n%s", strings.Join(args, " "), out)
+		}
+
+		// without a database commands may only fail, not crash.
+		if connected && err != nil {
+			t.Fatalf("concept %v failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
+func TestUnlockForceAdvisory(t *testing.T) {
+	config, _ := writeConfig(t, t.TempDir())
+
+	out, err := runCLI(t, "unlock", "--force", "--config", config)
+	if err == nil || !strings.Contains(out, "--force requires table locking") {
+		t.Fatalf("expected --force to be refused with advisory locking, got %v\n%s", err, out)
+	}
+}
+
+func TestStatusOutput(t *testing.T) {
+	dir := t.TempDir()
+	config, connected := writeConfig(t, dir)
+
+	out, err := runCLI(t, "status", "--output", "xml", "--config", config)
+	if err == nil || !strings.Contains(out, "unknown output format xml") {
+		t.Fatalf("expected unknown output format to be refused, got %v\n%s", err, out)
+	}
+
+	if !connected {
+		t.Skip("CONCEPT_MYSQL_URL is not set")
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "00001_create_users.sql"), []byte("CREATE TABLE users (id integer PRIMARY KEY);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	decoders := map[string]func(out string) ([]statusRow, error){
+		"json": func(out string) ([]statusRow, error) {
+			var rows []statusRow
+			return rows, json.Unmarshal([]byte(out), &rows)
+		},
+		"yaml": func(out string) ([]statusRow, error) {
+			var rows []statusRow
+			return rows, yaml.Unmarshal([]byte(out), &rows)
+		},
+		"csv": func(out string) ([]statusRow, error) {
+			records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+			if err != nil || len(records) == 0 || records[0][0] != "version" {
+				return nil, fmt.Errorf("unexpected records %v: %v", records, err)
+			}
+
+			rows := make([]statusRow, 0)
+			for _, record := range records[1:] {
+				rows = append(rows, statusRow{Version: record[0], State: strings.Split(record[7], "|")})
+			}
+			return rows, nil
+		},
+	}
+
+	for format, decode := range decoders {
+		out, err = runCLI(t, "status", "--output", format, "--pending", "--config", config)
+		if err != nil {
+			t.Fatalf("%v status failed: %v\n%s", format, err, out)
+		}
+
+		rows, err := decode(out)
+		if err != nil {
+			t.Fatalf("%v status is not valid: %v\n%s", format, err, out)
+		}
+
+		if len(rows) != 1 || rows[0].Version != "00001" || rows[0].State[0] != "Pending" {
+			t.Fatalf("unexpected %v status %+v", format, rows)
+		}
+	}
+
+	if out, err = runCLI(t, "status", "--fail-on-pending", "--config", config); err == nil {
+		t.Fatalf("expected pending migrations to fail the status\n%s", out)
+	}
+}
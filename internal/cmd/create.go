@@ -11,6 +11,7 @@ import (
 )
 
 var createWithReverseFile bool
+var createSingleFile bool
 
 var createCmd = &cobra.Command{
 	Use:   "create <name>",
@@ -24,13 +25,23 @@ var createCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(createCmd)
 	createCmd.Flags().BoolVar(&createWithReverseFile, "with-reverse", false, "create migration file with its reverse migration file")
+	createCmd.Flags().BoolVar(&createSingleFile, "single-file", false, "create a single migration file with up and down sections")
+	createCmd.MarkFlagsMutuallyExclusive("with-reverse", "single-file")
 }
 
 func conceptCreate(name string) {
 	con := newConcept(true, nil)
 
-	files, err := con.Create(name, createWithReverseFile)
-	cobra.CheckErr(err)
+	var files []string
+	if createSingleFile {
+		file, err := con.CreateSingleFile(name)
+		cobra.CheckErr(err)
+		files = []string{file}
+	} else {
+		var err error
+		files, err = con.Create(name, createWithReverseFile)
+		cobra.CheckErr(err)
+	}
 
 	fmt.Println("Migration files successfully created")
 	for _, name := range files {
@@ -0,0 +1,55 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var dumpOutput string
+var dumpWithData []string
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Dump the database schema",
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptDump()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.Flags().StringVarP(&dumpOutput, "output", "o", "", "Write the dump to this file instead of the standard output")
+	dumpCmd.Flags().StringSliceVar(&dumpWithData, "with-data", nil, "Comma separated tables whose rows are dumped too")
+}
+
+func conceptDump() {
+	con := newConcept(false, nil)
+	opts := database.DumpOptions{DataTables: dumpWithData}
+
+	if dumpOutput == "" {
+		cobra.CheckErr(con.Dump(os.Stdout, opts))
+		return
+	}
+
+	file, err := os.Create(dumpOutput)
+	cobra.CheckErr(err)
+
+	err = con.Dump(file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(dumpOutput)
+		cobra.CheckErr(err)
+	}
+
+	fmt.Println(color.GreenString("✔"), "Schema dumped to", dumpOutput)
+}
@@ -8,10 +8,19 @@ import (
 	_ "embed"
 	"fmt"
 	"github.com/dityaaa/concept"
+	"github.com/dityaaa/concept/database"
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/theckman/yacspin"
 )
 
 var migrateFresh bool
+var migrateSingleTransaction bool
+var migrateTarget string
+var migrateDryRun bool
+var migrateDryRunSQL bool
+var migrateOutOfOrder bool
+var migrateValidate bool
 
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
@@ -24,6 +33,12 @@ var migrateCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(migrateCmd)
 	migrateCmd.LocalFlags().BoolVar(&migrateFresh, "fresh", false, "Drop all tables an re-run all migrations")
+	migrateCmd.Flags().BoolVar(&migrateSingleTransaction, "single-transaction", false, "Apply all migrations in a single transaction")
+	migrateCmd.Flags().StringVar(&migrateTarget, "target", "", "Apply pending migrations up to and including this version")
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Show the migrations that would be applied without running them")
+	migrateCmd.Flags().BoolVar(&migrateDryRunSQL, "sql", false, "Print the full SQL of each planned migration, requires --dry-run")
+	migrateCmd.Flags().BoolVar(&migrateOutOfOrder, "out-of-order", false, "Apply pending migrations lower than the latest applied version")
+	migrateCmd.Flags().BoolVar(&migrateValidate, "validate", false, "Refuse to migrate when validation finds an issue")
 }
 
 func conceptMigrate() {
@@ -46,12 +61,31 @@ func conceptMigrate() {
 			spinner.StopFailMessage(fmt.Sprintf("%s (%dms)", mg.AdvanceScript.Identifier, mg.ExecutionTime))
 			spinner.StopFail()
 		},
+		Statement: statementProgress(spinner, concept.AdvanceDirection),
 	})
+	con.SetBatchTransaction(migrateSingleTransaction)
+	con.SetOutOfOrder(migrateOutOfOrder)
+	con.SetValidateOnMigrate(migrateValidate)
+
+	if migrateDryRun {
+		printPlan(con, concept.AdvanceDirection, migrateTarget, -1, migrateDryRunSQL)
+		return
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	var err error
+	if migrateTarget != "" {
+		printTargets(con, concept.AdvanceDirection, migrateTarget, "applied")
+		err = con.MigrateToContext(ctx, migrateTarget)
+	} else {
+		err = con.MigrateContext(ctx, -1)
+	}
 
-	err := con.Migrate(-1)
 	if err != nil {
 		spinner.StopFail()
-		cobra.CheckErr(err)
+		checkInterrupted(err)
 	}
 
 	if nothingToMigrate {
@@ -61,3 +95,66 @@ func conceptMigrate() {
 
 	fmt.Println("Database migration completed")
 }
+
+// statementProgress shows the statement being run in the spinner, next to the
+// script of direction.
+func statementProgress(spinner *yacspin.Spinner, direction concept.Direction) func(mg *concept.Migration, event *database.StatementEvent) {
+	return func(mg *concept.Migration, event *database.StatementEvent) {
+		script := mg.AdvanceScript
+		if direction == concept.ReverseDirection {
+			script = mg.ReverseScript
+		}
+
+		spinner.Message(fmt.Sprintf("%s (statement %d)", script.Identifier, event.Index))
+	}
+}
+
+// printTargets lists the versions a run towards target will go through, so the
+// user knows what is about to happen before anything is executed.
+func printTargets(con *concept.Concept, direction concept.Direction, target string, verb string) {
+	targets, err := con.Targets(direction, target)
+	cobra.CheckErr(err)
+
+	if len(targets) == 0 {
+		return
+	}
+
+	fmt.Printf("%d migration(s) will be %s:\n", len(targets), verb)
+	for _, mg := range targets {
+		fmt.Println(" -", mg.Version, mg.Description)
+	}
+}
+
+// printPlan shows what a run would do without touching the database. A
+// negative number of steps shows every planned step.
+func printPlan(con *concept.Concept, direction concept.Direction, target string, steps int, withSQL bool) {
+	plan, err := con.Plan(direction, target)
+	cobra.CheckErr(err)
+
+	if steps >= 0 && len(plan) > steps {
+		plan = plan[:steps]
+	}
+
+	fmt.Println(color.YellowString("Dry run, nothing is executed"))
+	if len(plan) == 0 {
+		fmt.Println("Nothing to do")
+		return
+	}
+
+	for c, step := range plan {
+		reversible := "irreversible"
+		if step.Reversible {
+			reversible = "reversible"
+		}
+
+		fmt.Printf("%d. [%s] %s %s (checksum %s, %s)\n", c+1, step.Direction, step.Version, step.Identifier, step.Checksum, reversible)
+
+		if withSQL {
+			sql, err := step.SQL()
+			cobra.CheckErr(err)
+
+			fmt.Println(sql)
+			fmt.Println()
+		}
+	}
+}
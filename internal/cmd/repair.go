@@ -0,0 +1,65 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var repairYes bool
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Fix the migration history after failed migrations",
+	Long: `Fix the migration history after failed migrations.
+Failed entries are removed so the migration is pending again, and the checksum and
+description of modified scripts are realigned with the migration history.
+Changes left in the database by a failed script must be reverted by hand first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptRepair()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+	repairCmd.Flags().BoolVarP(&repairYes, "yes", "y", false, "Repair without asking for confirmation")
+}
+
+func conceptRepair() {
+	con := newConcept(false, nil)
+
+	// failed or modified migrations are what repair is about.
+	refreshErr := con.Refresh()
+
+	actions, err := con.RepairPlan()
+	if err != nil {
+		cobra.CheckErr(refreshErr)
+		cobra.CheckErr(err)
+	}
+
+	if len(actions) == 0 {
+		fmt.Println("Nothing to repair")
+		return
+	}
+
+	fmt.Printf("%d repair(s) will be made:\n", len(actions))
+	for _, action := range actions {
+		fmt.Printf(" - %-13s %s\n", action.Kind, action.Message)
+	}
+
+	if !repairYes && !confirm("Repair the migration history?") {
+		fmt.Println("Repair cancelled")
+		return
+	}
+
+	actions, err = con.Repair()
+	cobra.CheckErr(err)
+
+	for _, action := range actions {
+		fmt.Println(color.GreenString("✔"), action.Message)
+	}
+}
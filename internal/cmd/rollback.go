@@ -11,6 +11,10 @@ import (
 )
 
 var rollbackSteps int
+var rollbackSingleTransaction bool
+var rollbackTarget string
+var rollbackDryRun bool
+var rollbackDryRunSQL bool
 
 var rollbackCmd = &cobra.Command{
 	Use:   "rollback",
@@ -23,6 +27,11 @@ var rollbackCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(rollbackCmd)
 	rollbackCmd.Flags().IntVar(&rollbackSteps, "steps", 1, "The number of migrations to be reverted")
+	rollbackCmd.Flags().BoolVar(&rollbackSingleTransaction, "single-transaction", false, "Revert all migrations in a single transaction")
+	rollbackCmd.Flags().StringVar(&rollbackTarget, "target", "", "Revert every migration above this version")
+	rollbackCmd.Flags().BoolVar(&rollbackDryRun, "dry-run", false, "Show the migrations that would be reverted without running them")
+	rollbackCmd.Flags().BoolVar(&rollbackDryRunSQL, "sql", false, "Print the full SQL of each planned migration, requires --dry-run")
+	rollbackCmd.MarkFlagsMutuallyExclusive("steps", "target")
 }
 
 func conceptRollback() {
@@ -32,25 +41,47 @@ func conceptRollback() {
 	fmt.Println("Preparing...")
 
 	con := newConcept(true, &concept.Hooks{
-		PreMigrate: func(mg *concept.Migration) {
+		PreRollback: func(mg *concept.Migration) {
 			nothingToRollback = false
-			spinner.Message(mg.AdvanceScript.Identifier)
+			spinner.Message(mg.ReverseScript.Identifier)
 			spinner.Start()
 		},
-		PostMigrate: func(mg *concept.Migration) {
-			spinner.StopMessage(fmt.Sprintf("%s (%dms)", mg.AdvanceScript.Identifier, mg.ExecutionTime))
+		PostRollback: func(mg *concept.Migration) {
+			spinner.StopMessage(fmt.Sprintf("%s (%dms)", mg.ReverseScript.Identifier, mg.ExecutionTime))
 			spinner.Stop()
 		},
-		MigrateErr: func(mg *concept.Migration, err error) {
-			spinner.StopFailMessage(fmt.Sprintf("%s (%dms)", mg.AdvanceScript.Identifier, mg.ExecutionTime))
+		RollbackErr: func(mg *concept.Migration, err error) {
+			spinner.StopFailMessage(fmt.Sprintf("%s (%dms)", mg.ReverseScript.Identifier, mg.ExecutionTime))
 			spinner.StopFail()
 		},
+		Statement: statementProgress(spinner, concept.ReverseDirection),
 	})
+	con.SetBatchTransaction(rollbackSingleTransaction)
+
+	if rollbackDryRun {
+		steps := rollbackSteps
+		if rollbackTarget != "" {
+			steps = -1
+		}
+
+		printPlan(con, concept.ReverseDirection, rollbackTarget, steps, rollbackDryRunSQL)
+		return
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	var err error
+	if rollbackTarget != "" {
+		printTargets(con, concept.ReverseDirection, rollbackTarget, "reverted")
+		err = con.RollbackToContext(ctx, rollbackTarget)
+	} else {
+		err = con.RollbackContext(ctx, rollbackSteps)
+	}
 
-	err := con.Rollback(rollbackSteps)
 	if err != nil {
 		spinner.StopFail()
-		cobra.CheckErr(err)
+		checkInterrupted(err)
 	}
 
 	if nothingToRollback {
@@ -5,13 +5,18 @@
 package cmd
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/dityaaa/concept"
 	"github.com/dityaaa/concept/database/mysql"
 	"github.com/dityaaa/concept/source/file"
 	mysql2 "github.com/go-sql-driver/mysql"
 	"github.com/theckman/yacspin"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -76,7 +81,13 @@ func newConcept(withDatabase bool, hooks *concept.Hooks) *concept.Concept {
 	dbDrv, err := mysql.WithInstance(db, mysql.Config{
 		HistoryTable: viper.GetString("history-table"),
 		LockingTable: viper.GetString("locking-table"),
+		LockingMode:  viper.GetString("locking-mode"),
+		LockTimeout:  viper.GetDuration("lock-timeout"),
+
+		HeartbeatInterval: viper.GetDuration("lock-heartbeat"),
+		LockExpiry:        viper.GetDuration("lock-expiry"),
 	})
+	cobra.CheckErr(err)
 
 	scDrv, err := file.Open("file://" + viper.GetString("migration-path"))
 
@@ -84,10 +95,32 @@ func newConcept(withDatabase bool, hooks *concept.Hooks) *concept.Concept {
 	cobra.CheckErr(err)
 
 	c.SetHooks(hooks)
+	c.SetEnvironment(viper.GetString("environment"))
+	c.SetPlaceholders(viper.GetStringMapString("placeholders"))
+
+	if withDatabase {
+		cobra.CheckErr(c.Refresh())
+	}
 
 	return c
 }
 
+// signalContext returns a context cancelled on SIGINT or SIGTERM, so a running
+// migration is interrupted and recorded as failed instead of killed halfway.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// checkInterrupted explains an interruption caused by signalContext before
+// exiting.
+func checkInterrupted(err error) {
+	if errors.Is(err, context.Canceled) {
+		cobra.CheckErr(fmt.Errorf("migration interrupted, the running migration is recorded as failed: %w", err))
+	}
+
+	cobra.CheckErr(err)
+}
+
 func newSpinner() *yacspin.Spinner {
 	cfg := yacspin.Config{
 		Frequency:         100 * time.Millisecond,
@@ -0,0 +1,41 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var squashUpTo string
+
+var squashCmd = &cobra.Command{
+	Use:   "squash",
+	Short: "Replace old migrations by a single script dumped from the database",
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptSquash()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(squashCmd)
+	squashCmd.Flags().StringVar(&squashUpTo, "up-to", "", "Squash every migration up to and including this version")
+	_ = squashCmd.MarkFlagRequired("up-to")
+}
+
+func conceptSquash() {
+	fmt.Println("Preparing...")
+	con := newConcept(true, nil)
+
+	created, removed, err := con.Squash(squashUpTo)
+	for _, name := range removed {
+		fmt.Println(color.RedString("-"), name)
+	}
+	cobra.CheckErr(err)
+
+	fmt.Println(color.GreenString("+"), created)
+	fmt.Printf("%d migration script(s) squashed into %s\n", len(removed), created)
+}
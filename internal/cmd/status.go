@@ -5,11 +5,23 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"github.com/dityaaa/concept"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
+var statusOutput string
+var statusPending bool
+var statusFailed bool
+var statusFailOnPending bool
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show the status of each migration",
@@ -20,16 +32,158 @@ var statusCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "Output format, one of table, json, yaml or csv")
+	statusCmd.Flags().BoolVar(&statusPending, "pending", false, "Only show pending migrations")
+	statusCmd.Flags().BoolVar(&statusFailed, "failed", false, "Only show failed migrations")
+	statusCmd.Flags().BoolVar(&statusFailOnPending, "fail-on-pending", false, "Exit with a non-zero code when a migration is pending")
+}
+
+type statusRow struct {
+	Version       string   `json:"version" yaml:"version"`
+	Description   string   `json:"description" yaml:"description"`
+	Type          string   `json:"type" yaml:"type"`
+	AppliedBy     string   `json:"applied_by" yaml:"applied_by"`
+	AppliedAt     string   `json:"applied_at" yaml:"applied_at"`
+	ExecutionTime uint32   `json:"execution_time_ms" yaml:"execution_time_ms"`
+	Statements    uint32   `json:"statements" yaml:"statements"`
+	State         []string `json:"state" yaml:"state"`
+	Reversible    bool     `json:"reversible" yaml:"reversible"`
 }
 
 func conceptStatus() {
-	fmt.Println("Preparing...")
-	mg := newConcept(true, nil)
+	switch statusOutput {
+	case "table", "json", "yaml", "csv":
+	default:
+		cobra.CheckErr(fmt.Errorf("unknown output format %v, expected table, json, yaml or csv", statusOutput))
+	}
 
-	res, err := mg.Get()
+	// machine readable outputs must not be mixed with progress messages.
+	if statusOutput == "table" {
+		fmt.Println("Preparing...")
+	}
+	con := newConcept(true, nil)
+
+	res, err := con.Get()
 	cobra.CheckErr(err)
 
-	for _, dt := range res {
-		fmt.Println(dt.AdvanceScript.Identifier, ";", concept.TranslateState(dt.State))
+	pending := false
+	rows := make([]statusRow, 0, len(res))
+	for _, mg := range res {
+		pending = pending || mg.Pending()
+
+		filtered := statusPending || statusFailed
+		if filtered && !(statusPending && mg.Pending()) && !(statusFailed && mg.Failed()) {
+			continue
+		}
+
+		row := statusRow{
+			Version:       mg.Version,
+			Description:   mg.Description,
+			Type:          mg.Type(),
+			AppliedBy:     mg.AppliedBy,
+			ExecutionTime: mg.ExecutionTime,
+			Statements:    mg.Statements,
+			State:         mg.State.Names(),
+			Reversible:    mg.Reversible(),
+		}
+
+		if mg.AppliedAt > 0 {
+			row.AppliedAt = time.Unix(int64(mg.AppliedAt), 0).Format(time.RFC3339)
+		}
+
+		rows = append(rows, row)
+	}
+
+	switch statusOutput {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		cobra.CheckErr(encoder.Encode(rows))
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		cobra.CheckErr(encoder.Encode(rows))
+		cobra.CheckErr(encoder.Close())
+	case "csv":
+		printStatusCSV(rows)
+	default:
+		printStatusTable(rows)
 	}
+
+	if statusFailOnPending && pending {
+		fmt.Fprintln(os.Stderr, "Pending migrations found")
+		os.Exit(1)
+	}
+}
+
+func printStatusTable(rows []statusRow) {
+	if len(rows) == 0 {
+		fmt.Println("No migration found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tTYPE\tAPPLIED BY\tAPPLIED AT\tTIME\tSTATEMENTS\tSTATE\tREVERSIBLE")
+
+	orDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+
+	for _, row := range rows {
+		executionTime := "-"
+		if row.AppliedAt != "" {
+			executionTime = fmt.Sprintf("%dms", row.ExecutionTime)
+		}
+
+		// failed migrations tell how many statements ran before the failure.
+		statements := "-"
+		if row.Statements > 0 {
+			statements = strconv.FormatUint(uint64(row.Statements), 10)
+		}
+
+		reversible := "no"
+		if row.Reversible {
+			reversible = "yes"
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			orDash(row.Version),
+			orDash(row.Description),
+			row.Type,
+			orDash(row.AppliedBy),
+			orDash(row.AppliedAt),
+			executionTime,
+			statements,
+			strings.Join(row.State, ", "),
+			reversible,
+		)
+	}
+
+	cobra.CheckErr(w.Flush())
+}
+
+func printStatusCSV(rows []statusRow) {
+	w := csv.NewWriter(os.Stdout)
+	cobra.CheckErr(w.Write([]string{"version", "description", "type", "applied_by", "applied_at", "execution_time_ms", "statements", "state", "reversible"}))
+
+	for _, row := range rows {
+		cobra.CheckErr(w.Write([]string{
+			row.Version,
+			row.Description,
+			row.Type,
+			row.AppliedBy,
+			row.AppliedAt,
+			strconv.FormatUint(uint64(row.ExecutionTime), 10),
+			strconv.FormatUint(uint64(row.Statements), 10),
+			strings.Join(row.State, "|"),
+			strconv.FormatBool(row.Reversible),
+		}))
+	}
+
+	w.Flush()
+	cobra.CheckErr(w.Error())
 }
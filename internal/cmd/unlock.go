@@ -0,0 +1,77 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database/mysql"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var unlockForce bool
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Show and release the migration lock",
+	Long: `Show the holder of the migration lock and release it.
+
+With advisory locking the lock belongs to the database session of its holder,
+the server frees it once that session ends and it cannot be released from
+here. Releasing a live holder with --force requires table locking.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptUnlock()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+	unlockCmd.Flags().BoolVar(&unlockForce, "force", false, "release the lock even if its holder is still alive, table locking only")
+}
+
+func conceptUnlock() {
+	mode := viper.GetString("locking-mode")
+	if unlockForce && (mode == "" || mode == mysql.LockingModeAdvisory) {
+		cobra.CheckErr(errors.New("--force requires table locking, an advisory lock is only freed once the session holding it ends"))
+	}
+
+	con := newConcept(false, nil)
+
+	lock, err := con.LockStatus()
+	cobra.CheckErr(err)
+
+	if !lock.Locked {
+		fmt.Println("Migration lock is not held")
+		return
+	}
+
+	formatTime := func(ts uint64) string {
+		if ts == 0 {
+			return "-"
+		}
+		return time.Unix(int64(ts), 0).Format(time.RFC3339)
+	}
+
+	fmt.Println("Migration lock is held by", lock.Owner)
+	fmt.Println("  host:          ", lock.Host)
+	fmt.Println("  pid:           ", lock.PID)
+	fmt.Println("  process start: ", formatTime(lock.ProcessStartedAt))
+	fmt.Println("  locked at:     ", formatTime(lock.LockedAt))
+	fmt.Println("  last heartbeat:", formatTime(lock.HeartbeatAt))
+
+	if lock.Stale {
+		fmt.Println(color.YellowString("Holder stopped sending heartbeats, the lock is stale"))
+	}
+
+	if !lock.Stale && !unlockForce {
+		cobra.CheckErr(errors.New("lock holder is still alive, use --force to release it anyway"))
+	}
+
+	cobra.CheckErr(con.ReleaseLock(unlockForce))
+	fmt.Println(color.GreenString("✔"), "Migration lock released")
+}
@@ -0,0 +1,79 @@
+// Copyright © 2022 Aditya Khoirul Anam <adit@ditya.dev>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var validateJSON bool
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Verify the migration scripts against the migration history",
+	Run: func(cmd *cobra.Command, args []string) {
+		conceptValidate()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().BoolVar(&validateJSON, "json", false, "Print the report as JSON")
+}
+
+type validateIssue struct {
+	Kind        string `json:"kind"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Identifier  string `json:"identifier"`
+	Message     string `json:"message"`
+}
+
+func conceptValidate() {
+	con := newConcept(false, nil)
+
+	// refresh errors such as unpaired scripts are part of the report.
+	refreshErr := con.Refresh()
+
+	report, err := con.Validate()
+	if err != nil {
+		cobra.CheckErr(refreshErr)
+		cobra.CheckErr(err)
+	}
+
+	if validateJSON {
+		issues := make([]validateIssue, 0, len(report.Issues))
+		for _, issue := range report.Issues {
+			issues = append(issues, validateIssue{
+				Kind:        string(issue.Kind),
+				Version:     issue.Version,
+				Description: issue.Description,
+				Identifier:  issue.Identifier,
+				Message:     issue.Message,
+			})
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		cobra.CheckErr(encoder.Encode(issues))
+	} else {
+		for _, issue := range report.Issues {
+			fmt.Println(color.RedString("✘"), fmt.Sprintf("[%s]", issue.Kind), issue.Message)
+		}
+
+		if report.Valid() {
+			fmt.Println(color.GreenString("✔"), "Migrations are valid")
+		}
+	}
+
+	if !report.Valid() {
+		fmt.Fprintf(os.Stderr, "Validation failed with %d issue(s)\n", len(report.Issues))
+		os.Exit(1)
+	}
+}
@@ -5,12 +5,21 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/spf13/cobra"
+	"os"
+	"time"
 )
 
+// Version is the build version, set with -ldflags "-X ...cmd.Version=".
+var Version = "dev"
+
+var versionJSON bool
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
-	Short: "Show the status of each migration",
+	Short: "Show the concept version and the current schema version",
 	Run: func(cmd *cobra.Command, args []string) {
 		conceptVersion()
 	},
@@ -18,8 +27,53 @@ var versionCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the versions as JSON")
+}
+
+type versionOutput struct {
+	Concept           string `json:"concept"`
+	SchemaVersion     string `json:"schema_version"`
+	Description       string `json:"description"`
+	AppliedBy         string `json:"applied_by"`
+	AppliedAt         string `json:"applied_at"`
+	PendingMigrations int    `json:"pending_migrations"`
+	FailedMigrations  int    `json:"failed_migrations"`
 }
 
 func conceptVersion() {
-	panic("implement me")
+	con := newConcept(true, nil)
+
+	current, err := con.SchemaVersion()
+	cobra.CheckErr(err)
+
+	out := versionOutput{
+		Concept:           Version,
+		SchemaVersion:     current.Version,
+		Description:       current.Description,
+		AppliedBy:         current.AppliedBy,
+		PendingMigrations: current.Pending,
+		FailedMigrations:  current.Failed,
+	}
+
+	if current.AppliedAt > 0 {
+		out.AppliedAt = time.Unix(int64(current.AppliedAt), 0).Format(time.RFC3339)
+	}
+
+	if versionJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		cobra.CheckErr(encoder.Encode(out))
+		return
+	}
+
+	fmt.Println("Concept version:", out.Concept)
+	if out.SchemaVersion == "" {
+		fmt.Println("Schema version:  none, no migration applied yet")
+	} else {
+		fmt.Println("Schema version: ", out.SchemaVersion, out.Description)
+		fmt.Println("Applied by:     ", out.AppliedBy)
+		fmt.Println("Applied at:     ", out.AppliedAt)
+	}
+	fmt.Println("Pending:        ", out.PendingMigrations)
+	fmt.Println("Failed:         ", out.FailedMigrations)
 }
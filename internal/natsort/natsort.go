@@ -139,5 +139,4 @@ func Compare(a, b string) bool {
 
 		return av < bv
 	}
-	return false
 }
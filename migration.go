@@ -8,6 +8,60 @@ type Migration struct {
 	ExecutionTime uint32
 	State         state
 
+	// Statements is the number of statements of the last run that succeeded,
+	// it tells where a failed migration stopped.
+	Statements uint32
+
+	// Repeatable migrations have no version, they are identified by their
+	// description and only use the advance script.
+	Repeatable bool
+
+	// OutOfOrder is true when the migration has been applied below an already
+	// applied version.
+	OutOfOrder bool
+
 	AdvanceScript *Script
 	ReverseScript *Script
 }
+
+const (
+	VersionedType  = "versioned"
+	RepeatableType = "repeatable"
+	BaselineType   = "baseline"
+	SquashType     = "squash"
+)
+
+// Type returns the kind of the migration, one of the *Type constants.
+func (i *Migration) Type() string {
+	if i.Repeatable {
+		return RepeatableType
+	}
+
+	if i.State&baselineState > 0 && i.AdvanceScript == nil && i.ReverseScript == nil {
+		return BaselineType
+	}
+
+	if i.Description == SquashDescription {
+		return SquashType
+	}
+
+	return VersionedType
+}
+
+// Pending returns true when the migration is waiting to be applied, or to be
+// re-applied for an outdated repeatable migration. Migrations excluded from the
+// current environment are not pending.
+func (i *Migration) Pending() bool {
+	return i.State&(pendingState|outdatedState) > 0 && i.State&excludedState == 0
+}
+
+// Reversible returns true when the migration can be rolled back, it needs a
+// reverse script and no irreversible directive.
+func (i *Migration) Reversible() bool {
+	return i.ReverseScript != nil && (i.AdvanceScript == nil || !i.AdvanceScript.Directives.Irreversible)
+}
+
+// Failed returns true when the last run of the migration failed.
+func (i *Migration) Failed() bool {
+	return i.State&failedState > 0
+}
@@ -0,0 +1,107 @@
+package concept
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches ${name} placeholders, $${name} is the escaped form
+// and stays as ${name} in the script.
+var placeholderPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// PlaceholderEnvPrefix prefixes the environment variables defining
+// placeholders, ${schema} is read from CONCEPT_PLACEHOLDER_SCHEMA.
+const PlaceholderEnvPrefix = "CONCEPT_PLACEHOLDER_"
+
+// SetPlaceholders sets the values of the ${name} placeholders of the scripts.
+// Names are case-insensitive, environment variables prefixed with
+// PlaceholderEnvPrefix take precedence over these values.
+func (i *Concept) SetPlaceholders(placeholders map[string]string) {
+	i.placeholders = make(map[string]string, len(placeholders))
+	for name, value := range placeholders {
+		i.placeholders[strings.ToLower(name)] = value
+	}
+}
+
+func (i *Concept) placeholder(name string) (string, bool) {
+	env := PlaceholderEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+	if value, exists := os.LookupEnv(env); exists {
+		return value, true
+	}
+
+	value, exists := i.placeholders[strings.ToLower(name)]
+	return value, exists
+}
+
+// substitute replaces the placeholders of a script content. The checksum is
+// computed on the raw content, so the history does not depend on the values.
+func (i *Concept) substitute(script *Script, content []byte) ([]byte, error) {
+	if !bytes.Contains(content, []byte("${")) {
+		return content, nil
+	}
+
+	undefined := make([]string, 0)
+	result := placeholderPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+		if match[1] == '$' {
+			return match[1:]
+		}
+
+		name := string(match[2 : len(match)-1])
+		value, exists := i.placeholder(name)
+		if !exists {
+			for _, known := range undefined {
+				if known == name {
+					return match
+				}
+			}
+
+			undefined = append(undefined, name)
+			return match
+		}
+
+		return []byte(value)
+	})
+
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("concept: undefined placeholders %v in %v", undefined, script.Identifier)
+	}
+
+	return result, nil
+}
+
+// checkPlaceholders makes sure every placeholder of the targets is defined
+// before any of them is run.
+func (i *Concept) checkPlaceholders(targets []*Migration, direction Direction) error {
+	for _, mg := range targets {
+		script := mg.AdvanceScript
+		if direction == ReverseDirection {
+			script = mg.ReverseScript
+		}
+
+		if script.fn != nil {
+			continue
+		}
+
+		content, err := script.Content()
+		if err != nil {
+			return err
+		}
+
+		if _, err = i.substitute(script, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapePlaceholders escapes every placeholder of content, e.g. for a dump
+// written as a script, which must run as it is.
+func escapePlaceholders(content []byte) []byte {
+	return placeholderPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+		return append([]byte("$"), match...)
+	})
+}
@@ -0,0 +1,69 @@
+package concept
+
+// Step is a single script execution planned by Plan.
+type Step struct {
+	Version     string
+	Description string
+	Identifier  string
+	Direction   Direction
+	Checksum    string
+
+	// Reversible is true when the migration has a reverse script, so the step
+	// can be undone later.
+	Reversible bool
+
+	script  *Script
+	concept *Concept
+}
+
+// SQL returns the script content exactly as it would be sent to the database
+// driver, placeholders replaced.
+func (i *Step) SQL() (string, error) {
+	content, err := i.script.Content()
+	if err != nil {
+		return "", err
+	}
+
+	if i.script.fn == nil {
+		if content, err = i.concept.substitute(i.script, content); err != nil {
+			return "", err
+		}
+	}
+
+	return string(content), nil
+}
+
+// Plan returns, in execution order, the steps that MigrateTo or RollbackTo would
+// run for the given direction and target version, without executing anything
+// nor writing to the migration history. An empty target plans every migration.
+func (i *Concept) Plan(direction Direction, target string) ([]*Step, error) {
+	targets, err := i.Targets(direction, target)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]*Step, 0, len(targets))
+	for _, mg := range targets {
+		script, scriptDirection := mg.AdvanceScript, direction
+		if direction == ReverseDirection {
+			script = mg.ReverseScript
+		}
+
+		if mg.Repeatable {
+			scriptDirection = RepeatableDirection
+		}
+
+		steps = append(steps, &Step{
+			Version:     mg.Version,
+			Description: mg.Description,
+			Identifier:  script.Identifier,
+			Direction:   scriptDirection,
+			Checksum:    script.Checksum(),
+			Reversible:  mg.Reversible(),
+			script:      script,
+			concept:     i,
+		})
+	}
+
+	return steps, nil
+}
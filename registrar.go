@@ -3,12 +3,16 @@ package concept
 import (
 	"github.com/dityaaa/concept/database"
 	"github.com/dityaaa/concept/database/mysql"
+	"github.com/dityaaa/concept/database/postgres"
+	"github.com/dityaaa/concept/database/sqlite"
 	"github.com/dityaaa/concept/source"
 	"github.com/dityaaa/concept/source/file"
 )
 
 func init() {
 	database.Register(&mysql.MySQL{}, mysql.Open)
+	database.Register(&postgres.Postgres{}, postgres.Open)
+	database.Register(&sqlite.SQLite{}, sqlite.Open)
 
 	source.Register(&file.File{}, file.Open)
 }
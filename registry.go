@@ -0,0 +1,127 @@
+package concept
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"github.com/dityaaa/concept/internal/natsort"
+)
+
+// GoFunc is the body of a migration written in Go. db is the transaction the
+// migration runs in when there is one, the database handle of the driver
+// otherwise.
+type GoFunc func(ctx context.Context, db database.Executor) error
+
+// GoMigration is a migration written in Go, it is merged by version with the
+// scripts of the source driver. Its checksum is derived from its identifier,
+// i.e. its version and description, so it takes part in the history,
+// validation and status like any script.
+type GoMigration struct {
+	Version     string
+	Description string
+
+	Advance GoFunc
+
+	// Reverse is optional, without it the migration cannot be rolled back.
+	Reverse GoFunc
+}
+
+// Registry holds the Go migrations of a Concept.
+type Registry struct {
+	migrations map[string]*GoMigration
+}
+
+// DefaultRegistry is used by every Concept unless SetRegistry is called.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		migrations: make(map[string]*GoMigration, 0),
+	}
+}
+
+// Register adds a Go migration to the registry.
+func (r *Registry) Register(migration *GoMigration) error {
+	if migration.Version == "" {
+		return errors.New("concept: go migration version cannot be empty")
+	}
+
+	if migration.Advance == nil {
+		return fmt.Errorf("concept: go migration %v has no advance function", migration.Version)
+	}
+
+	if _, exists := r.migrations[migration.Version]; exists {
+		return fmt.Errorf("concept: go migration %v is registered more than once", migration.Version)
+	}
+
+	r.migrations[migration.Version] = migration
+	return nil
+}
+
+// Register adds a Go migration to DefaultRegistry, reverse may be nil. It is
+// meant to be called from init functions and panics on invalid migrations.
+func Register(version, description string, advance, reverse GoFunc) {
+	err := DefaultRegistry.Register(&GoMigration{
+		Version:     version,
+		Description: description,
+		Advance:     advance,
+		Reverse:     reverse,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// scripts returns the scripts of every registered migration, ordered by
+// version.
+func (r *Registry) scripts() []*Script {
+	versions := make([]string, 0, len(r.migrations))
+	for version := range r.migrations {
+		versions = append(versions, version)
+	}
+	natsort.Sort(versions)
+
+	scripts := make([]*Script, 0, len(versions))
+	for _, version := range versions {
+		mg := r.migrations[version]
+
+		name := mg.Version
+		if mg.Description != "" {
+			name += "_" + mg.Description
+		}
+
+		if mg.Reverse == nil {
+			scripts = append(scripts, newGoScript(mg, name+".go", AdvanceDirection, mg.Advance))
+			continue
+		}
+
+		scripts = append(scripts,
+			newGoScript(mg, name+".adv.go", AdvanceDirection, mg.Advance),
+			newGoScript(mg, name+".rev.go", ReverseDirection, mg.Reverse),
+		)
+	}
+
+	return scripts
+}
+
+// SetRegistry replaces the registry Go migrations are read from, it must be
+// called before Refresh.
+func (i *Concept) SetRegistry(registry *Registry) {
+	i.registry = registry
+}
+
+// runGo runs a Go migration with the database handle of the driver, which is
+// the running transaction if any.
+func (i *Concept) runGo(ctx context.Context, fn GoFunc) error {
+	handler, ok := i.databaseDriver.(database.Handler)
+	if !ok {
+		return fmt.Errorf("concept: %v driver does not support go migrations", i.databaseDriver.Name())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fn(ctx, handler.Handle())
+}
@@ -0,0 +1,252 @@
+package concept
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/database"
+	"strings"
+	"time"
+)
+
+type RepairKind string
+
+const (
+	// RemoveFailedRepair removes the failed history entries of a migration, it
+	// is then pending again. Whatever the failed script did to the schema must
+	// have been cleaned up by hand.
+	RemoveFailedRepair RepairKind = "remove-failed"
+
+	// RealignRepair rewrites the checksum and description recorded for an
+	// applied migration with the ones of its current script.
+	RealignRepair RepairKind = "realign"
+)
+
+// RepairAction is a single change made by Repair.
+type RepairAction struct {
+	Kind        RepairKind
+	Version     string
+	Description string
+	Repeatable  bool
+	Message     string
+}
+
+// RepairPlan returns the actions Repair would take, without changing the
+// history, so they can be confirmed first.
+func (i *Concept) RepairPlan() ([]*RepairAction, error) {
+	if err := i.checkRepairable(); err != nil {
+		return nil, err
+	}
+
+	actions := make([]*RepairAction, 0)
+	for _, version := range i.versions {
+		mg := i.migrations[version]
+
+		if mg.State&failedState > 0 {
+			actions = append(actions, &RepairAction{
+				Kind:        RemoveFailedRepair,
+				Version:     version,
+				Description: mg.Description,
+				Message:     fmt.Sprintf("remove failed entries of %v%v", version, stoppedAt(mg)),
+			})
+			continue
+		}
+
+		_, mismatch := i.mismatches[version]
+		modified := mg.State&futureState > 0 && mg.State&successState > 0
+		if mg.AdvanceScript != nil && (mismatch || modified) {
+			actions = append(actions, &RepairAction{
+				Kind:        RealignRepair,
+				Version:     version,
+				Description: mg.Description,
+				Message:     fmt.Sprintf("realign checksum and description of %v with %v", version, mg.AdvanceScript.Identifier),
+			})
+		}
+	}
+
+	for _, name := range i.repeatableNames {
+		if mg := i.repeatables[name]; mg.State&failedState > 0 {
+			actions = append(actions, &RepairAction{
+				Kind:        RemoveFailedRepair,
+				Description: name,
+				Repeatable:  true,
+				Message:     fmt.Sprintf("remove failed entries of repeatable %v%v", name, stoppedAt(mg)),
+			})
+		}
+	}
+
+	return actions, nil
+}
+
+// checkRepairable refuses to plan a repair over migrations that were never
+// refreshed, an empty plan would wrongly tell there is nothing to repair.
+func (i *Concept) checkRepairable() error {
+	if i.synced {
+		return nil
+	}
+
+	if i.latestErr != nil {
+		return i.latestErr
+	}
+
+	return errors.New("concept: migrations must be refreshed before repair")
+}
+
+// stoppedAt tells where a failed migration stopped, when the driver recorded
+// it, so that its leftovers can be cleaned up by hand.
+func stoppedAt(mg *Migration) string {
+	if mg.Statements == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (stopped at statement %d, previous ones succeeded)", mg.Statements+1)
+}
+
+// Repair applies the actions of RepairPlan, as they are once the shared lock is
+// held, then records an audit entry describing them in the history. It
+// returns the actions taken.
+func (i *Concept) Repair() ([]*RepairAction, error) {
+	return i.RepairContext(context.Background())
+}
+
+// RepairContext is like Repair, changing the history stops when ctx is done.
+func (i *Concept) RepairContext(ctx context.Context) ([]*RepairAction, error) {
+	if err := i.checkRepairable(); err != nil {
+		return nil, err
+	}
+
+	deleter, ok := i.databaseDriver.(database.HistoryDeleter)
+	if !ok {
+		return nil, fmt.Errorf("concept: %v driver does not support history repair", i.databaseDriver.Name())
+	}
+
+	// lock is not used since it refuses to go on with the very issues repair
+	// is about to fix.
+	locker, ok := i.databaseDriver.(database.Locker)
+	if ok && locker.Lockable() {
+		if err := database.LockContext(ctx, locker); err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = locker.Unlock()
+		}()
+
+		if err := i.sync(ctx); err != nil && !i.synced {
+			return nil, err
+		}
+	}
+
+	actions, err := i.RepairPlan()
+	if err != nil || len(actions) == 0 {
+		return actions, err
+	}
+
+	for _, action := range actions {
+		if action.Kind == RemoveFailedRepair {
+			err = i.removeFailed(ctx, deleter, action)
+		} else {
+			err = i.realign(ctx, action)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	messages := make([]string, 0, len(actions))
+	for _, action := range actions {
+		messages = append(messages, action.Message)
+	}
+
+	audit := "repair: " + strings.Join(messages, ", ")
+	if len(audit) > 255 {
+		audit = audit[:252] + "..."
+	}
+
+	err = database.WriteContext(ctx, i.databaseDriver, &database.History{
+		Mode:        RepairDirection,
+		ScriptName:  "<< Repair >>",
+		Description: audit,
+		AppliedAt:   uint64(time.Now().Unix()),
+		Success:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	i.latestErr = i.sync(ctx)
+	if i.latestErr == nil && len(i.unpaired) > 0 {
+		i.latestErr = fmt.Errorf("concept: found %v rev without adv migration %v", len(i.unpaired), i.unpaired)
+	}
+
+	return actions, nil
+}
+
+// removeFailed deletes the latest entry of the migration as long as it is a
+// failed one, older failed entries may show up once a newer one is gone.
+func (i *Concept) removeFailed(ctx context.Context, deleter database.HistoryDeleter, action *RepairAction) error {
+	for {
+		histories, err := database.ReadContext(ctx, i.databaseDriver)
+		if err != nil {
+			return err
+		}
+
+		var latest *database.History
+		for _, history := range histories {
+			repeatable := Direction(history.Mode) == RepeatableDirection
+			if repeatable != action.Repeatable || Direction(history.Mode) == RepairDirection {
+				continue
+			}
+
+			if (repeatable && history.Version == action.Description) || (!repeatable && history.Version == action.Version) {
+				latest = history
+			}
+		}
+
+		if latest == nil || latest.Success {
+			return nil
+		}
+
+		if err = deleter.Delete(latest); err != nil {
+			return err
+		}
+	}
+}
+
+// realign rewrites every entry of the migration with the description and the
+// checksums of its current scripts.
+func (i *Concept) realign(ctx context.Context, action *RepairAction) error {
+	mg := i.migrations[action.Version]
+
+	histories, err := database.ReadContext(ctx, i.databaseDriver)
+	if err != nil {
+		return err
+	}
+
+	for _, history := range histories {
+		if history.Version != action.Version {
+			continue
+		}
+
+		script := mg.AdvanceScript
+		switch Direction(history.Mode) {
+		case ReverseDirection:
+			script = mg.ReverseScript
+		case AdvanceDirection, OutOfOrderDirection, SquashDirection:
+		default:
+			continue
+		}
+
+		history.Description = mg.Description
+		if script != nil {
+			history.ScriptName = script.Identifier
+			history.Checksum = script.Checksum()
+		}
+
+		if err = database.WriteContext(ctx, i.databaseDriver, history); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
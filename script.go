@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"strings"
 )
 
 type Direction string
@@ -12,6 +13,30 @@ type Direction string
 const (
 	AdvanceDirection = "ADV"
 	ReverseDirection = "REV"
+
+	// RepeatableDirection is the history mode of repeatable migrations, they
+	// are applied again every time their checksum changes.
+	RepeatableDirection = "RPT"
+
+	// OutOfOrderDirection is the history mode of advance scripts applied below
+	// an already applied version.
+	OutOfOrderDirection = "OOO"
+
+	// BaselineDirection is the history mode of the baseline entry, every
+	// migration at or below its version is considered as applied.
+	BaselineDirection = "BSL"
+
+	// SquashDirection is the history mode recorded by Squash, the database
+	// already satisfies the squash script of that version.
+	SquashDirection = "SQH"
+
+	// RepairDirection is the history mode of the audit entries written by
+	// Repair, they are not migrations and are skipped when reading the history.
+	RepairDirection = "RPR"
+
+	// SquashDescription names the scripts written by Squash, e.g.
+	// 00042_squash.sql. Such a script replaces every migration up to its version.
+	SquashDescription = "squash"
 )
 
 type Script struct {
@@ -19,9 +44,29 @@ type Script struct {
 	Identifier  string
 	Description string
 	Direction   Direction
+	Directives  Directives
 
 	content  io.ReadCloser
+	raw      []byte
 	checksum string
+
+	// fn is run instead of the content for migrations written in Go.
+	fn GoFunc
+}
+
+// newGoScript returns the script of a Go migration. Its content only names the
+// migration, the checksum therefore changes along with its identifier.
+func newGoScript(mg *GoMigration, identifier string, direction Direction, fn GoFunc) *Script {
+	script := &Script{
+		Version:     mg.Version,
+		Identifier:  identifier,
+		Description: mg.Description,
+		Direction:   direction,
+		fn:          fn,
+	}
+	script.SetContent(io.NopCloser(strings.NewReader("-- go migration " + identifier + "\n")))
+
+	return script
 }
 
 func (i *Script) Read(p []byte) (n int, err error) {
@@ -34,25 +79,44 @@ func (i *Script) Close() error {
 
 func (i *Script) SetContent(rd io.ReadCloser) {
 	i.content = rd
+	i.raw = nil
+	i.checksum = ""
 }
 
-func (i *Script) Checksum() string {
-	if i.checksum != "" {
-		return i.checksum
+// Content returns the whole script, exactly as it is sent to the database
+// driver. Reading it does not consume the script.
+func (i *Script) Content() ([]byte, error) {
+	if i.raw != nil {
+		return i.raw, nil
 	}
 
 	rawContent, err := io.ReadAll(i.content)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// does not use defer because i.content is replaced by NopCloser
 	err = i.Close()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	i.content = io.NopCloser(bytes.NewReader(rawContent))
+	i.raw = rawContent
+
+	return i.raw, nil
+}
+
+func (i *Script) Checksum() string {
+	if i.checksum != "" {
+		return i.checksum
+	}
+
+	rawContent, err := i.Content()
+	if err != nil {
+		panic(err)
+	}
+
 	i.checksum = fmt.Sprintf("%x", md5.Sum(rawContent))
 
 	return i.checksum
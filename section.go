@@ -0,0 +1,92 @@
+package concept
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dityaaa/concept/database/splitter"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// sectionPattern matches the lines starting the sections of a single-file
+// migration, e.g.
+//
+//	-- +concept Up
+//	CREATE TABLE users (id integer PRIMARY KEY);
+//
+//	-- +concept Down
+//	DROP TABLE users;
+var sectionPattern = regexp.MustCompile(`(?i)^--\s*\+concept\s+(up|down)$`)
+
+// singleFileTemplate is the content of the migrations created by
+// CreateSingleFile.
+const singleFileTemplate = "-- +concept Up\n\n\n-- +concept Down\n\n"
+
+// splitSections splits a single-file migration into its up and down sections.
+// The script keeps the up section, along with whatever comes before it, and
+// the down section is returned as the reverse script, nil when there is none
+// or it holds no statement, so that rollback is refused.
+// Scripts without sections are left untouched.
+func (i *Script) splitSections() (*Script, error) {
+	content, err := i.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Contains(bytes.ToLower(content), []byte("+concept")) {
+		return nil, nil
+	}
+
+	var up, down bytes.Buffer
+	current := &up
+	seen := make(map[string]bool, 2)
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		matches := sectionPattern.FindSubmatch(bytes.TrimSpace(line))
+		if matches != nil {
+			section := strings.ToLower(string(matches[1]))
+			if seen[section] {
+				return nil, fmt.Errorf("concept: duplicate %v section in %v", section, i.Identifier)
+			}
+			seen[section] = true
+
+			current = &up
+			if section == "down" {
+				current = &down
+			}
+		}
+
+		current.Write(line)
+	}
+
+	if !seen["up"] {
+		if seen["down"] {
+			return nil, fmt.Errorf("concept: %v has a down section without an up section", i.Identifier)
+		}
+
+		return nil, nil
+	}
+
+	i.SetContent(io.NopCloser(bytes.NewReader(up.Bytes())))
+	if !seen["down"] || emptySection(down.String()) {
+		return nil, nil
+	}
+
+	reverse := &Script{
+		Version:     i.Version,
+		Identifier:  i.Identifier,
+		Description: i.Description,
+		Direction:   ReverseDirection,
+	}
+	reverse.SetContent(io.NopCloser(bytes.NewReader(down.Bytes())))
+
+	return reverse, nil
+}
+
+// emptySection tells whether section is made of comments and blank lines only.
+// A section the splitter cannot make sense of is left for the driver to report.
+func emptySection(section string) bool {
+	statements, err := splitter.Split(section, splitter.Generic)
+	return err == nil && len(statements) == 0
+}
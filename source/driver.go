@@ -48,6 +48,13 @@ type Driver interface {
 	Err() error
 }
 
+// Writer is implemented by drivers able to create a migration with content.
+type Writer interface {
+	// Write creates, or replaces, the migration with the given name and returns
+	// its identifier.
+	Write(name string, content io.Reader) (string, error)
+}
+
 func Open(url string) (Driver, error) {
 	purl, err := nurl.Parse(url)
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 )
 
 var _ source.Driver = (*File)(nil)
+var _ source.Writer = (*File)(nil)
 
 type Config struct {
 	MigrationPath string
@@ -87,12 +88,41 @@ func (i *File) Read() (*source.Migration, error) {
 }
 
 func (i *File) Touch(name string) error {
-	_, err := os.Create(name)
-	return err
+	file, err := os.Create(i.path(name))
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func (i *File) Write(name string, content io.Reader) (string, error) {
+	path := i.path(name)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return path, err
 }
 
 func (i *File) Remove(name string) error {
-	return os.Remove(name)
+	return os.Remove(i.path(name))
+}
+
+// path resolves a bare file name inside the migration path, identifiers
+// returned by Read already include it.
+func (i *File) path(name string) string {
+	if filepath.Dir(name) == "." {
+		return filepath.Join(i.migrationPath, name)
+	}
+
+	return name
 }
 
 func (i *File) Err() error {
@@ -0,0 +1,111 @@
+package iofs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dityaaa/concept/source"
+	"io"
+	"io/fs"
+)
+
+var _ source.Driver = (*IOFS)(nil)
+
+type Config struct {
+	// MigrationPath is the directory of the migrations inside the filesystem,
+	// the root of the filesystem when empty.
+	MigrationPath string
+}
+
+// IOFS reads migrations from any fs.FS, e.g. an embed.FS shipped inside the
+// binary. Sub-directories are read as well. The filesystem is read-only, so
+// Touch and Remove always fail.
+type IOFS struct {
+	fsys          fs.FS
+	migrationPath string
+
+	paths         []string
+	curIndex      int
+	curIdentifier string
+	curScript     io.ReadCloser
+	curError      error
+}
+
+// WithInstance returns a source reading the migrations of fsys.
+func WithInstance(fsys fs.FS, config Config) (source.Driver, error) {
+	if fsys == nil {
+		return nil, errors.New("iofs: filesystem cannot be nil")
+	}
+
+	migrationPath := config.MigrationPath
+	if migrationPath == "" {
+		migrationPath = "."
+	}
+
+	paths := make([]string, 0)
+	err := fs.WalkDir(fsys, migrationPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &IOFS{
+		fsys:          fsys,
+		migrationPath: migrationPath,
+		paths:         paths,
+	}, nil
+}
+
+func (i *IOFS) Name() string {
+	return "iofs"
+}
+
+func (i *IOFS) Close() error {
+	return nil
+}
+
+func (i *IOFS) Next() bool {
+	if i.curIndex >= len(i.paths) {
+		i.paths = nil
+		return false
+	}
+
+	path := i.paths[i.curIndex]
+	file, err := i.fsys.Open(path)
+	if err != nil {
+		i.curError = err
+		return false
+	}
+
+	i.curIdentifier = path
+	i.curScript = file
+	i.curIndex++
+	return true
+}
+
+func (i *IOFS) Read() (*source.Migration, error) {
+	return &source.Migration{
+		Identifier: i.curIdentifier,
+		Script:     i.curScript,
+	}, nil
+}
+
+func (i *IOFS) Touch(name string) error {
+	return fmt.Errorf("iofs: cannot create %v, the source is read-only", name)
+}
+
+func (i *IOFS) Remove(name string) error {
+	return fmt.Errorf("iofs: cannot remove %v, the source is read-only", name)
+}
+
+func (i *IOFS) Err() error {
+	return i.curError
+}
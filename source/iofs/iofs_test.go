@@ -0,0 +1,65 @@
+package iofs
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRead(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/00001_create_users.sql":       {Data: []byte("CREATE TABLE users (id integer);")},
+		"migrations/2023/00002_create_posts.sql":  {Data: []byte("CREATE TABLE posts (id integer);")},
+		"migrations/2023/R__refresh_views.sql":    {Data: []byte("SELECT 1;")},
+		"fixtures/00003_not_a_migration_here.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	drv, err := WithInstance(fsys, Config{MigrationPath: "migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(map[string]string)
+	for drv.Next() {
+		mg, err := drv.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(mg.Script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = mg.Script.Close()
+
+		read[mg.Identifier] = string(content)
+	}
+
+	if err = drv.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"migrations/00001_create_users.sql":      "CREATE TABLE users (id integer);",
+		"migrations/2023/00002_create_posts.sql": "CREATE TABLE posts (id integer);",
+		"migrations/2023/R__refresh_views.sql":   "SELECT 1;",
+	}
+	if !reflect.DeepEqual(read, expected) {
+		t.Fatalf("unexpected migrations %v, expected %v", read, expected)
+	}
+
+	if err = drv.Touch("00004_create_tags.sql"); err == nil {
+		t.Fatal("expected touch to fail on a read-only source")
+	}
+
+	if err = drv.Remove("migrations/00001_create_users.sql"); err == nil {
+		t.Fatal("expected remove to fail on a read-only source")
+	}
+}
+
+func TestMissingPath(t *testing.T) {
+	if _, err := WithInstance(fstest.MapFS{}, Config{MigrationPath: "migrations"}); err == nil {
+		t.Fatal("expected a missing migration path to fail")
+	}
+}
@@ -21,6 +21,10 @@ type state uint16
 //outdatedState is a repeatable migration that is outdated and should be re-applied
 //supersededState is a repeatable migration that is outdated and has already been
 //superseded by a newer one
+//baselineState means that migration is considered applied because its version is
+//at or below the database baseline.
+//excludedState means that migration is restricted to other environments by its
+//env directive.
 
 const (
 	unknownState state = 0
@@ -31,17 +35,27 @@ const (
 	missingState
 	failedState
 	futureState
+	outdatedState
+	supersededState
+	ignoredState
+	baselineState
+	excludedState
 )
 
 var stateMap = map[state]string{
-	unknownState:   "Unknown",
-	pendingState:   "Pending",
-	successState:   "Success",
-	availableState: "Available",
-	undoneState:    "Undone",
-	missingState:   "Missing",
-	failedState:    "Failed",
-	futureState:    "Future",
+	unknownState:    "Unknown",
+	pendingState:    "Pending",
+	successState:    "Success",
+	availableState:  "Available",
+	undoneState:     "Undone",
+	missingState:    "Missing",
+	failedState:     "Failed",
+	futureState:     "Future",
+	outdatedState:   "Outdated",
+	supersededState: "Superseded",
+	ignoredState:    "Ignored",
+	baselineState:   "Baseline",
+	excludedState:   "Excluded",
 }
 
 func (i state) unknown() error {
@@ -88,14 +102,22 @@ func (i state) future() error {
 	return fmt.Errorf("invalid [%v] transition. current state is %v", stateMap[futureState], i.String())
 }
 
-func (i state) String() string {
-	states := make([]string, 0)
+// Names returns the name of every state set, in bit order.
+func (i state) Names() []string {
+	if i == unknownState {
+		return []string{stateMap[unknownState]}
+	}
 
-	for s, t := range stateMap {
+	states := make([]string, 0)
+	for s := pendingState; s <= excludedState; s <<= 1 {
 		if s&i == s {
-			states = append(states, t)
+			states = append(states, stateMap[s])
 		}
 	}
 
-	return strings.Join(states, ", ")
+	return states
+}
+
+func (i state) String() string {
+	return strings.Join(i.Names(), ", ")
 }
@@ -0,0 +1,135 @@
+package concept
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type IssueKind string
+
+const (
+	// ModifiedIssue is an applied script changed since it was applied.
+	ModifiedIssue IssueKind = "modified"
+
+	// MissingIssue is a migration recorded in the history without script.
+	MissingIssue IssueKind = "missing"
+
+	// UnpairedIssue is a reverse script without advance script.
+	UnpairedIssue IssueKind = "unpaired"
+
+	// DescriptionIssue is a script whose description differs from the one
+	// recorded in the history for the same version.
+	DescriptionIssue IssueKind = "description"
+
+	// FailedIssue is a migration whose last run failed.
+	FailedIssue IssueKind = "failed"
+)
+
+// Issue is a single problem found by Validate.
+type Issue struct {
+	Kind        IssueKind
+	Version     string
+	Description string
+	Identifier  string
+	Message     string
+}
+
+// ValidationReport lists, in version order, the problems found by Validate.
+type ValidationReport struct {
+	Issues []*Issue
+}
+
+// Valid returns true when no issue has been found.
+func (i *ValidationReport) Valid() bool {
+	return len(i.Issues) == 0
+}
+
+// Err summarizes the issues as an error, nil when the report is valid.
+func (i *ValidationReport) Err() error {
+	if i.Valid() {
+		return nil
+	}
+
+	messages := make([]string, 0, len(i.Issues))
+	for _, issue := range i.Issues {
+		messages = append(messages, issue.Message)
+	}
+
+	return fmt.Errorf("concept: validation failed with %v issues: %v", len(i.Issues), strings.Join(messages, "; "))
+}
+
+// SetValidateOnMigrate makes the next migrate runs refuse to apply anything
+// when Validate finds an issue.
+func (i *Concept) SetValidateOnMigrate(enabled bool) {
+	i.validateOnMigrate = enabled
+}
+
+// Validate checks the source scripts against the migration history. It
+// reports modified applied scripts, missing scripts, unpaired reverse scripts,
+// description mismatches and failed migrations.
+func (i *Concept) Validate() (*ValidationReport, error) {
+	if !i.synced {
+		if i.latestErr != nil {
+			return nil, i.latestErr
+		}
+
+		return nil, errors.New("concept: migrations must be refreshed before validation")
+	}
+
+	report := &ValidationReport{Issues: make([]*Issue, 0)}
+	add := func(kind IssueKind, mg *Migration, script *Script, format string, args ...any) {
+		issue := &Issue{
+			Kind:        kind,
+			Version:     mg.Version,
+			Description: mg.Description,
+			Message:     fmt.Sprintf(format, args...),
+		}
+
+		if script != nil {
+			issue.Identifier = script.Identifier
+		}
+
+		report.Issues = append(report.Issues, issue)
+	}
+
+	for _, version := range i.versions {
+		mg := i.migrations[version]
+
+		if mg.AdvanceScript == nil && mg.ReverseScript != nil {
+			add(UnpairedIssue, mg, mg.ReverseScript, "version %v has a reverse script without advance script", version)
+		}
+
+		if recorded, exists := i.mismatches[version]; exists {
+			add(DescriptionIssue, mg, mg.AdvanceScript, "version %v is described as %q, history recorded %q", version, mg.Description, recorded)
+		}
+
+		i.validateState(mg, add)
+	}
+
+	for _, name := range i.repeatableNames {
+		i.validateState(i.repeatables[name], add)
+	}
+
+	return report, nil
+}
+
+func (i *Concept) validateState(mg *Migration, add func(IssueKind, *Migration, *Script, string, ...any)) {
+	name := mg.Version
+	if mg.Repeatable {
+		name = "repeatable " + mg.Description
+	}
+
+	if mg.State&missingState > 0 {
+		add(MissingIssue, mg, nil, "%v is recorded in the history but has no script", name)
+	}
+
+	// changing the script of a failed migration is how it gets fixed.
+	if mg.State&futureState > 0 && mg.State&successState > 0 {
+		add(ModifiedIssue, mg, mg.AdvanceScript, "%v has been modified after being applied", name)
+	}
+
+	if mg.State&failedState > 0 {
+		add(FailedIssue, mg, mg.AdvanceScript, "%v failed and needs to be repaired", name)
+	}
+}
@@ -0,0 +1,47 @@
+package concept
+
+// SchemaVersion describes the current version of the database schema.
+type SchemaVersion struct {
+	// Version is the highest successfully applied version, empty when nothing
+	// has been applied yet.
+	Version     string
+	Description string
+	AppliedBy   string
+	AppliedAt   uint64
+
+	Pending int
+	Failed  int
+}
+
+// SchemaVersion returns the current version of the database schema together
+// with the number of pending and failed migrations.
+func (i *Concept) SchemaVersion() (*SchemaVersion, error) {
+	migrations, err := i.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	current := &SchemaVersion{}
+	for _, mg := range migrations {
+		if mg.Pending() {
+			current.Pending++
+		}
+
+		if mg.Failed() {
+			current.Failed++
+		}
+
+		applied := (mg.State&successState > 0 && mg.State&undoneState == 0) || mg.State&baselineState > 0
+		if mg.Repeatable || !applied {
+			continue
+		}
+
+		// migrations are sorted by version, the last applied one wins.
+		current.Version = mg.Version
+		current.Description = mg.Description
+		current.AppliedBy = mg.AppliedBy
+		current.AppliedAt = mg.AppliedAt
+	}
+
+	return current, nil
+}